@@ -2,6 +2,12 @@ module goBackup
 
 go 1.22.1
 
-require github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible
+require (
+	github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible
+	github.com/klauspost/pgzip v1.2.6
+)
 
-require github.com/technoweenie/multipartstreamer v1.0.1 // indirect
+require (
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/technoweenie/multipartstreamer v1.0.1 // indirect
+)
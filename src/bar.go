@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	bar_width    = 30
+	bar_interval = 100 * time.Millisecond
+)
+
+// show_progress_bars is set when stdout is a terminal, so progress bars are
+// drawn on manual runs and never end up in cron mail or redirected logs.
+var show_progress_bars = stdout_is_terminal()
+
+// bar_output serialises drawing, as tasks run concurrently.
+var bar_output sync.Mutex
+
+func stdout_is_terminal() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressBar tracks how far one step of a task has got and redraws a
+// single terminal line at most every bar_interval. A nil bar ignores every
+// call, so callers need not check whether bars are shown.
+type progressBar struct {
+	mu     sync.Mutex
+	label  string
+	done   int64
+	total  int64
+	detail string
+	last   time.Time
+}
+
+// new_progress_bar returns a bar for label, or nil when stdout is not a
+// terminal.
+func new_progress_bar(label string, total int64) *progressBar {
+	if !show_progress_bars {
+		return nil
+	}
+	return &progressBar{label: label, total: total}
+}
+
+// percent is how much of total is done, from 0 to 100.
+func (b *progressBar) percent() int {
+	if b.total <= 0 {
+		return 0
+	}
+	if b.done >= b.total {
+		return 100
+	}
+	return int(b.done * 100 / b.total)
+}
+
+// add counts n more files processed.
+func (b *progressBar) add(n int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done += n
+	b.detail = fmt.Sprintf("%d/%d files", b.done, b.total)
+	b.draw(false)
+}
+
+// set replaces the progress with done of total and a detail shown after
+// the bar.
+func (b *progressBar) set(done, total int64, detail string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done, b.total, b.detail = done, total, detail
+	b.draw(false)
+}
+
+// finish draws the final state and ends the line.
+func (b *progressBar) finish() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.draw(true)
+}
+
+func (b *progressBar) render() string {
+	filled := b.percent() * bar_width / 100
+	return fmt.Sprintf("%s [%s%s] %3d%% %s", b.label, strings.Repeat("#", filled), strings.Repeat(".", bar_width-filled), b.percent(), b.detail)
+}
+
+func (b *progressBar) draw(final bool) {
+	if now := time.Now(); final || now.Sub(b.last) >= bar_interval {
+		b.last = now
+		end := ""
+		if final {
+			end = "\n"
+		}
+		bar_output.Lock()
+		fmt.Fprintf(os.Stdout, "\r%s\x1b[K%s", b.render(), end)
+		bar_output.Unlock()
+	}
+}
+
+// rclone_stats_pattern matches the transfer part of the line rclone logs
+// with --stats-one-line, e.g. "1.500 MiB / 10.000 MiB, 15%".
+var rclone_stats_pattern = regexp.MustCompile(`(\d[\d.]* ?\S*B) / (\d[\d.]* ?\S*B), (\d+)%`)
+
+// rcloneStatsWriter passes rclone's output through to writer, moving bar
+// along with the stats lines in it and handing each of them to report.
+type rcloneStatsWriter struct {
+	writer io.Writer
+	bar    *progressBar
+	report func(stats string)
+}
+
+func (r rcloneStatsWriter) Write(p []byte) (int, error) {
+	for _, match := range rclone_stats_pattern.FindAllSubmatch(p, -1) {
+		percent, _ := strconv.ParseInt(string(match[3]), 10, 64)
+		r.bar.set(percent, 100, string(match[1])+" / "+string(match[2]))
+		if r.report != nil {
+			r.report(string(match[0]))
+		}
+	}
+	return r.writer.Write(p)
+}
+
+// rclone_output runs cmd like CombinedOutput, moving bar along with the
+// stats rclone logs while it runs and passing them to report when set.
+func rclone_output(cmd *exec.Cmd, bar *progressBar, report func(stats string)) ([]byte, error) {
+	if bar == nil && report == nil {
+		return cmd.CombinedOutput()
+	}
+	var output bytes.Buffer
+	// The same writer for both makes exec copy them in one goroutine.
+	writer := rcloneStatsWriter{writer: &output, bar: bar, report: report}
+	cmd.Stdout, cmd.Stderr = writer, writer
+	err := cmd.Run()
+	return output.Bytes(), err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// quiet_bar returns a bar that never draws during the test.
+func quiet_bar(label string, total int64) *progressBar {
+	return &progressBar{label: label, total: total, last: time.Now().Add(time.Hour)}
+}
+
+func TestProgressBarRender(t *testing.T) {
+	tests := []struct {
+		done  int64
+		total int64
+		want  string
+	}{
+		{0, 0, "Archiving site [..............................]   0% "},
+		{0, 40, "Archiving site [..............................]   0% 0/40 files"},
+		{10, 40, "Archiving site [#######.......................]  25% 10/40 files"},
+		{40, 40, "Archiving site [##############################] 100% 40/40 files"},
+		{41, 40, "Archiving site [##############################] 100% 41/40 files"},
+	}
+	for _, tt := range tests {
+		bar := quiet_bar("Archiving site", tt.total)
+		if tt.total > 0 {
+			bar.add(tt.done)
+		}
+		if got := bar.render(); got != tt.want {
+			t.Errorf("%d/%d: render = %q, want %q", tt.done, tt.total, got, tt.want)
+		}
+	}
+
+	var none *progressBar
+	none.add(1)
+	none.set(1, 2, "")
+	none.finish()
+	saved := show_progress_bars
+	show_progress_bars = false
+	defer func() { show_progress_bars = saved }()
+	if bar := new_progress_bar("Archiving site", 10); bar != nil {
+		t.Error("new_progress_bar drew a bar without a terminal")
+	}
+}
+
+func TestRcloneStatsWriter(t *testing.T) {
+	tests := []struct {
+		line    string
+		percent int
+		detail  string
+	}{
+		{"2026/10/14 10:00:00 NOTICE:    1.500 MiB / 10.000 MiB, 15%, 512 KiB/s, ETA 17s\n", 15, "1.500 MiB / 10.000 MiB"},
+		{"Transferred:   	  512 B / 1 KiB, 50%, 0 B/s, ETA -\n", 50, "512 B / 1 KiB"},
+		{"2026/10/14 10:00:00 INFO  : site-20261014-100000.zip: Copied (new)\n", 0, ""},
+	}
+	for _, tt := range tests {
+		var output bytes.Buffer
+		var reported []string
+		bar := quiet_bar("Uploading site", 100)
+		writer := rcloneStatsWriter{writer: &output, bar: bar, report: func(stats string) { reported = append(reported, stats) }}
+		writer.Write([]byte(tt.line))
+		if output.String() != tt.line {
+			t.Errorf("passed through %q, want %q", output.String(), tt.line)
+		}
+		if bar.percent() != tt.percent || bar.detail != tt.detail {
+			t.Errorf("%q moved the bar to %d%% %q, want %d%% %q", tt.line, bar.percent(), bar.detail, tt.percent, tt.detail)
+		}
+		if got := len(reported) == 1 && strings.HasPrefix(reported[0], tt.detail); got != (tt.detail != "") {
+			t.Errorf("%q reported %q", tt.line, reported)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type catalogEntry struct {
+	Task     string    `json:"Task"`
+	Name     string    `json:"Name"`
+	Time     time.Time `json:"Time"`
+	Size     int64     `json:"Size"`
+	Location string    `json:"Location"`
+}
+
+var backup_timestamp_pattern = regexp.MustCompile(`-(\d{8}-\d{6})`)
+
+// catalog_time reads the backup time from name, falling back to fallback
+// for files not named by goBack.
+func catalog_time(task BackupTask, name string, fallback time.Time) time.Time {
+	match := backup_timestamp_pattern.FindStringSubmatch(name)
+	if match == nil {
+		return fallback
+	}
+	location, err := time.LoadLocation(task.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	t, err := time.ParseInLocation("20060102-150405", match[1], location)
+	if err != nil {
+		return fallback
+	}
+	return t
+}
+
+// is_backup_file reports whether name is a backup rather than goBack state
+// or a sidecar describing one.
+func is_backup_file(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	for _, suffix := range backup_sidecar_suffixes {
+		if strings.HasSuffix(name, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+func local_catalog(task BackupTask) []catalogEntry {
+	var entries []catalogEntry
+	pattern := task_file_pattern(task)
+	files, _ := os.ReadDir(task.StorePath)
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil || info.IsDir() || !pattern.MatchString(file.Name()) || !is_backup_file(file.Name()) {
+			continue
+		}
+		entries = append(entries, catalogEntry{
+			Task:     task_name(task),
+			Name:     file.Name(),
+			Time:     catalog_time(task, file.Name(), info.ModTime()),
+			Size:     info.Size(),
+			Location: filepath.Join(task.StorePath, file.Name()),
+		})
+	}
+	return entries
+}
+
+func remote_catalog(task BackupTask) ([]catalogEntry, error) {
+	output, err := run_command(binary(task.RclonePath, "rclone"), "lsjson", "--files-only", task.RemotePath).Output()
+	if err != nil {
+		return nil, err
+	}
+	var files []struct {
+		Name    string
+		Size    int64
+		ModTime time.Time
+	}
+	if err := json.Unmarshal(output, &files); err != nil {
+		return nil, err
+	}
+	var entries []catalogEntry
+	pattern := task_file_pattern(task)
+	for _, file := range files {
+		// Other tasks may share the remote directory.
+		if !pattern.MatchString(file.Name) || !is_backup_file(file.Name) {
+			continue
+		}
+		entries = append(entries, catalogEntry{
+			Task:     task_name(task),
+			Name:     file.Name,
+			Time:     catalog_time(task, file.Name, file.ModTime),
+			Size:     file.Size,
+			Location: task.RemotePath + "/" + file.Name,
+		})
+	}
+	return entries, nil
+}
+
+// print_catalog lists the backups of every task, locally and optionally on
+// the remotes, as a table or as JSON.
+func print_catalog(config Config, format string, remote bool) error {
+	var entries []catalogEntry
+	for _, task := range all_tasks(config) {
+		entries = append(entries, local_catalog(task)...)
+		if remote && task.RemotePath != "" {
+			remote_entries, err := remote_catalog(task)
+			if err != nil {
+				return fmt.Errorf("listing %s: %v", task.RemotePath, err)
+			}
+			entries = append(entries, remote_entries...)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Task != entries[j].Task {
+			return entries[i].Task < entries[j].Task
+		}
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	if format == "json" {
+		data, err := json.MarshalIndent(entries, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK\tTIME\tSIZE\tLOCATION")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Task, e.Time.Format("2006-01-02 15:04:05 MST"), format_bytes(uint64(e.Size)), e.Location)
+	}
+	return w.Flush()
+}
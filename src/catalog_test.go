@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCatalogTime(t *testing.T) {
+	fallback := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name     string
+		timezone string
+		want     time.Time
+	}{
+		{"site-20261014-100000.zip", "", time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)},
+		{"site-20261014-100000.part002.zip", "", time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)},
+		{"site-20261014-100000.zip", "Europe/Berlin", time.Date(2026, 10, 14, 8, 0, 0, 0, time.UTC)},
+		{"site-notes.txt", "", fallback},
+		{"site-20261399-100000.zip", "", fallback},
+	}
+	for _, tt := range tests {
+		got := catalog_time(BackupTask{Timezone: tt.timezone}, tt.name, fallback)
+		if !got.Equal(tt.want) {
+			t.Errorf("catalog_time(%s in %q) = %v, want %v", tt.name, tt.timezone, got, tt.want)
+		}
+	}
+}
+
+func TestIsBackupFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		backup bool
+	}{
+		{"site-20261014-100000.zip", true},
+		{"shop-20261014-100000.sql.gz", true},
+		{"site-20261014-100000.zip" + file_list_suffix, false},
+		{"shop-20261014-100000.sql" + restore_script_suffix, false},
+		{delta_state_file, false},
+		{partial_prefix + "site-20261014-100000.zip", false},
+	}
+	for _, tt := range tests {
+		if got := is_backup_file(tt.name); got != tt.backup {
+			t.Errorf("is_backup_file(%s) = %v, want %v", tt.name, got, tt.backup)
+		}
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	dir := t.TempDir()
+	store := filepath.Join(dir, "store")
+	os.MkdirAll(store, 0755)
+	for _, name := range []string{
+		"site-20261014-100000.zip",
+		"site-20261013-100000.zip",
+		"site-20261013-100000.zip" + file_list_suffix,
+		"site-staging-20261014-100000.zip",
+		delta_state_file,
+	} {
+		os.WriteFile(filepath.Join(store, name), []byte("data"), 0644)
+	}
+	rclone := filepath.Join(dir, "rclone")
+	listing := `[{"Name":"site-20261012-100000.zip","Size":7,"ModTime":"2026-10-12T10:00:05Z"},` +
+		`{"Name":"site-staging-20261012-100000.zip","Size":7,"ModTime":"2026-10-12T10:00:05Z"},` +
+		`{"Name":"site-20261012-100000.zip.filelist.txt","Size":1,"ModTime":"2026-10-12T10:00:05Z"}]`
+	os.WriteFile(rclone, []byte("#!/bin/sh\necho '"+listing+"'\n"), 0755)
+	task := BackupTask{Website: "site", StorePath: store, RemotePath: "r:site", RclonePath: rclone}
+
+	var names []string
+	for _, entry := range local_catalog(task) {
+		names = append(names, entry.Location)
+	}
+	want := filepath.Join(store, "site-20261013-100000.zip") + "," + filepath.Join(store, "site-20261014-100000.zip")
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("local catalog = %s, want %s", got, want)
+	}
+
+	entries, err := remote_catalog(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Location != "r:site/site-20261012-100000.zip" || entries[0].Size != 7 {
+		t.Errorf("remote catalog = %+v, want only r:site/site-20261012-100000.zip", entries)
+	}
+}
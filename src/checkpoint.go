@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// splitCheckpoint records how far a Checkpoint task got through its split
+// archive, so an interrupted run resumes at the first unfinished part.
+type splitCheckpoint struct {
+	Target string     `json:"Target"`
+	Parts  [][]string `json:"Parts"`
+	Done   int        `json:"Done"`
+}
+
+func checkpoint_file(task BackupTask) string {
+	return filepath.Join(task.StorePath, ".goBack-checkpoint-"+task_name(task)+".json")
+}
+
+// load_checkpoint returns the checkpoint of an interrupted run of the task,
+// or nil when there is none.
+func load_checkpoint(task BackupTask) *splitCheckpoint {
+	data, err := os.ReadFile(checkpoint_file(task))
+	if err != nil {
+		return nil
+	}
+	var checkpoint splitCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil || checkpoint.Target == "" {
+		log.Printf("Ignoring unreadable checkpoint %s: %v", checkpoint_file(task), err)
+		return nil
+	}
+	return &checkpoint
+}
+
+func save_checkpoint(task BackupTask, checkpoint *splitCheckpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	path := checkpoint_file(task)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// checkpoint_part_name is where a part is written until the whole archive
+// is done. The partial_prefix keeps an unfinished archive out of rotation,
+// restores and uploads, even when the run fails before resuming it.
+func checkpoint_part_name(part string) string {
+	return partial_name(final_name(part)) + ".tmp"
+}
+
+// existing_paths drops the paths removed from the source since the plan
+// was made, so a resumed part does not fail on them.
+func existing_paths(paths []string) []string {
+	var kept []string
+	for _, path := range paths {
+		if _, err := os.Lstat(path); err == nil {
+			kept = append(kept, path)
+		}
+	}
+	return kept
+}
+
+// write_checkpointed_parts writes the parts planned in checkpoint that are
+// not done yet, recording each one as it finishes, so a run interrupted
+// at any point resumes at the first unfinished part. The parts get their
+// final names only once all of them are written.
+func write_checkpointed_parts(task BackupTask, source string, checkpoint *splitCheckpoint, open map[string]bool) ([]string, error) {
+	if err := save_checkpoint(task, checkpoint); err != nil {
+		return nil, err
+	}
+	ext := archive_extension(task)
+	for i := checkpoint.Done; i < len(checkpoint.Parts); i++ {
+		temp := checkpoint_part_name(split_part_name(checkpoint.Target, ext, i+1))
+		if err := write_zip_part(task, source, temp, existing_paths(checkpoint.Parts[i]), open); err != nil {
+			os.Remove(temp)
+			return nil, err
+		}
+		checkpoint.Done = i + 1
+		if err := save_checkpoint(task, checkpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	var files []string
+	for i := range checkpoint.Parts {
+		part := split_part_name(checkpoint.Target, ext, i+1)
+		temp := checkpoint_part_name(part)
+		if err := os.Rename(temp, part); err != nil {
+			return files, err
+		}
+		for _, suffix := range backup_sidecar_suffixes {
+			if _, err := os.Stat(temp + suffix); err == nil {
+				if err := os.Rename(temp+suffix, part+suffix); err != nil {
+					return files, err
+				}
+			}
+		}
+		files = append(files, part)
+	}
+	os.Remove(checkpoint_file(task))
+	return files, nil
+}
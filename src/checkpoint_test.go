@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	dir := t.TempDir()
+	source := write_split_source(t, dir)
+	store := filepath.Join(dir, "store")
+	os.MkdirAll(store, 0755)
+	task := BackupTask{Website: "site", BackupSource: source, StorePath: store, SplitBySize: 100, Checkpoint: true}
+	if err := validate_task(task); err != nil {
+		t.Fatal(err)
+	}
+
+	// A run interrupted after two of its four parts: they are written
+	// under hidden names and the checkpoint records them as done.
+	target := filepath.Join(store, "site-20261014-100000.zip")
+	var parts [][]string
+	for _, name := range []string{"a/1.html", "a/2.html", "b/3.html", "c.txt"} {
+		parts = append(parts, []string{filepath.Join(source, name)})
+	}
+	for i := 0; i < 2; i++ {
+		os.WriteFile(checkpoint_part_name(split_part_name(target, ".zip", i+1)), []byte("done before"), 0644)
+	}
+	if err := save_checkpoint(task, &splitCheckpoint{Target: target, Parts: parts, Done: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if sets := backup_sets(task); len(sets) != 0 {
+		t.Errorf("unfinished parts are in rotation: %v", sets)
+	}
+	// A file removed since the plan was made is left out of its part.
+	os.Remove(filepath.Join(source, "c.txt"))
+
+	// The next run resumes the interrupted archive rather than starting
+	// its own.
+	files, err := archive_source(task, filepath.Join(store, "site-20261014-110000.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, filepath.Base(file))
+	}
+	want := "site-20261014-100000.part001.zip,site-20261014-100000.part002.zip,site-20261014-100000.part003.zip,site-20261014-100000.part004.zip"
+	if strings.Join(names, ",") != want {
+		t.Fatalf("wrote %v, want %s", names, want)
+	}
+	for i, file := range files {
+		data, _ := os.ReadFile(file)
+		if resumed := string(data) == "done before"; resumed != (i < 2) {
+			t.Errorf("part %d rewritten: %v", i+1, !resumed)
+		}
+	}
+	if got := strings.Join(zip_names(t, files[2]), ","); got != "site/,site/b/,site/b/3.html" {
+		t.Errorf("part 3 holds %s", got)
+	}
+	if got := strings.Join(zip_names(t, files[3]), ","); got != "" {
+		t.Errorf("part 4 holds %s, want nothing", got)
+	}
+	if got := strings.Join(remaining(store), ","); got != want {
+		t.Errorf("StorePath holds %s, want only the parts", got)
+	}
+	if load_checkpoint(task) != nil {
+		t.Error("checkpoint kept after the archive was finished")
+	}
+
+	for _, invalid := range []BackupTask{
+		{Website: "site", Checkpoint: true},
+		{Website: "site", Checkpoint: true, SplitBySize: 100, RestoreScript: true},
+	} {
+		if err := validate_task(invalid); err == nil {
+			t.Errorf("validate_task accepted %+v", invalid)
+		}
+	}
+}
+
+// Parts of a checkpointed archive stay local until it is finished, even
+// when a failed run uploads the StorePath.
+func TestCheckpointPartsNotUploaded(t *testing.T) {
+	dir := t.TempDir()
+	store := filepath.Join(dir, "store")
+	os.MkdirAll(store, 0755)
+	target := partial_name(filepath.Join(store, "site-20261014-100000.zip"))
+	for i := 0; i < 2; i++ {
+		os.WriteFile(checkpoint_part_name(split_part_name(target, ".zip", i+1)), []byte("unfinished"), 0644)
+	}
+	os.WriteFile(filepath.Join(store, "site-20261013-100000.zip"), []byte("finished"), 0644)
+
+	task := BackupTask{Website: "site", StorePath: store, RemotePath: "r:x", RclonePath: fake_rclone(t, dir)}
+	if err := copy_backup_to_onedrive(task, "", 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(remaining(filepath.Join(dir, "remote")), ","); got != "site-20261013-100000.zip" {
+		t.Errorf("remote holds %s, want only the finished backup", got)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	endian "encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	default_clock_server   = "pool.ntp.org"
+	default_clock_max_skew = time.Minute
+	clock_timeout          = 5 * time.Second
+)
+
+// ntp_epoch_offset is the number of seconds from the NTP epoch, 1900, to the
+// Unix epoch.
+const ntp_epoch_offset = 2208988800
+
+// ClockCheck compares the system clock with an NTP server before any
+// backup is timestamped.
+type ClockCheck struct {
+	Server  string `json:"Server,omitempty"`
+	MaxSkew string `json:"MaxSkew,omitempty"`
+	Refuse  bool   `json:"Refuse,omitempty"`
+}
+
+func validate_clock_check(check ClockCheck) error {
+	if check.MaxSkew == "" {
+		return nil
+	}
+	if skew, err := time.ParseDuration(check.MaxSkew); err != nil || skew <= 0 {
+		return fmt.Errorf("invalid CheckClock MaxSkew %q", check.MaxSkew)
+	}
+	return nil
+}
+
+// ntp_offset asks server for the time with one SNTP request and returns how
+// far the server's clock is ahead of the system clock.
+func ntp_offset(server string) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	conn, err := net.DialTimeout("udp", server, clock_timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(clock_timeout))
+
+	request := make([]byte, 48)
+	request[0] = 0x23 // leap indicator 0, version 4, client mode
+	sent := time.Now()
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+	response := make([]byte, 48)
+	if n, err := conn.Read(response); err != nil {
+		return 0, err
+	} else if n < len(response) {
+		return 0, fmt.Errorf("short response of %d bytes from %s", n, server)
+	}
+	received := time.Now()
+
+	seconds := endian.BigEndian.Uint32(response[40:44])
+	fraction := endian.BigEndian.Uint32(response[44:48])
+	if seconds == 0 {
+		return 0, fmt.Errorf("%s sent no transmit time", server)
+	}
+	server_time := time.Unix(int64(seconds)-ntp_epoch_offset, int64(fraction)*1e9>>32)
+	// Take the server time to be that at the middle of the round trip.
+	local := sent.Add(received.Sub(sent) / 2)
+	return server_time.Sub(local), nil
+}
+
+// check_clock warns, and with Refuse returns an error, when the system
+// clock is more than MaxSkew away from the server's. A server that cannot
+// be reached only logs a warning, so an NTP outage does not stop backups.
+func check_clock(check ClockCheck, botToken string, chatID int64, enable bool) error {
+	server := check.Server
+	if server == "" {
+		server = default_clock_server
+	}
+	max_skew := default_clock_max_skew
+	if check.MaxSkew != "" {
+		max_skew, _ = time.ParseDuration(check.MaxSkew)
+	}
+
+	offset, err := ntp_offset(server)
+	if err != nil {
+		log.Printf("Warning: cannot check the clock against %s: %v", server, err)
+		return nil
+	}
+	skew := offset
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew <= max_skew {
+		return nil
+	}
+	direction := "behind"
+	if offset < 0 {
+		direction = "ahead of"
+	}
+	message := fmt.Sprintf("Clock Check FAILED: system clock is %s %s %s", skew.Round(time.Second), direction, server)
+	log.Print(message)
+	send_message(botToken, chatID, message, enable)
+	if check.Refuse {
+		return fmt.Errorf("clock skew of %s exceeds %s", skew.Round(time.Second), max_skew)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	endian "encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fake_ntp_server answers SNTP requests on a local port with the system
+// time moved by offset, and returns its address.
+func fake_ntp_server(t *testing.T, offset time.Duration) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		request := make([]byte, 48)
+		for {
+			_, addr, err := conn.ReadFrom(request)
+			if err != nil {
+				return
+			}
+			now := time.Now().Add(offset)
+			response := make([]byte, 48)
+			response[0] = 0x24 // version 4, server mode
+			endian.BigEndian.PutUint32(response[40:], uint32(now.Unix()+ntp_epoch_offset))
+			endian.BigEndian.PutUint32(response[44:], uint32((int64(now.Nanosecond())<<32)/1e9))
+			conn.WriteTo(response, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestNtpOffset(t *testing.T) {
+	for _, offset := range []time.Duration{0, 90 * time.Second, -2 * time.Hour} {
+		got, err := ntp_offset(fake_ntp_server(t, offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := got - offset; diff < -time.Second || diff > time.Second {
+			t.Errorf("offset %v measured as %v", offset, got)
+		}
+	}
+}
+
+func TestCheckClock(t *testing.T) {
+	tests := []struct {
+		name    string
+		offset  time.Duration
+		check   ClockCheck
+		message string
+		fails   bool
+	}{
+		{"in sync", 2 * time.Second, ClockCheck{}, "", false},
+		{"behind", 10 * time.Minute, ClockCheck{}, "Clock Check FAILED: system clock is 10m0s behind", false},
+		{"ahead, refused", -10 * time.Minute, ClockCheck{Refuse: true}, "Clock Check FAILED: system clock is 10m0s ahead of", true},
+		{"within MaxSkew", 10 * time.Minute, ClockCheck{MaxSkew: "15m", Refuse: true}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := record_messages(t)
+			check := tt.check
+			check.Server = fake_ntp_server(t, tt.offset)
+			err := check_clock(check, "token", 1, true)
+			if (err != nil) != tt.fails {
+				t.Fatalf("check_clock = %v, want failure %v", err, tt.fails)
+			}
+			got := strings.Join(bot.messages(), ",")
+			if tt.message == "" && got != "" || !strings.HasPrefix(got, tt.message) {
+				t.Errorf("sent %q, want %q", got, tt.message)
+			}
+		})
+	}
+
+	// An unreachable server only warns.
+	conn, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	server := conn.LocalAddr().String()
+	conn.Close()
+	if err := check_clock(ClockCheck{Server: server, Refuse: true}, "", 0, false); err != nil {
+		t.Errorf("check_clock with an unreachable server = %v", err)
+	}
+
+	for skew, valid := range map[string]bool{"": true, "30s": true, "0s": false, "-1m": false, "a minute": false} {
+		if err := validate_clock_check(ClockCheck{MaxSkew: skew}); (err == nil) != valid {
+			t.Errorf("validate_clock_check(%q) = %v, want valid %v", skew, err, valid)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/klauspost/pgzip"
+)
+
+type compressor struct {
+	name string
+	ext  string
+	run  func(source, target string) error
+}
+
+var best_compressors = []compressor{
+	{"gzip", ".gz", gzip_compressor(pgzip.BestCompression, 0, 0)},
+	{"zstd", ".zst", command_compressor("zstd", "-19", "-q", "-c")},
+	{"xz", ".xz", command_compressor("xz", "-9", "-c")},
+}
+
+const default_gzip_block_size = 1 << 20
+
+// gzip_compressor compresses with pgzip, which deflates blocks of
+// block_size bytes on workers cores at once and joins them into one
+// standard gzip stream. Zero picks 1 MiB blocks and every core.
+func gzip_compressor(level, block_size, workers int) func(source, target string) error {
+	if block_size <= 0 {
+		block_size = default_gzip_block_size
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return func(source, target string) error {
+		in, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		writer, err := pgzip.NewWriterLevel(out, level)
+		if err != nil {
+			return err
+		}
+		if err := writer.SetConcurrency(block_size, workers); err != nil {
+			return err
+		}
+		if _, err := io.Copy(writer, contextReader{in}); err != nil {
+			return err
+		}
+		return writer.Close()
+	}
+}
+
+// command_compressor compresses with an external tool that writes the
+// compressed stream to stdout.
+func command_compressor(name string, args ...string) func(source, target string) error {
+	return func(source, target string) error {
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		cmd := run_command(name, append(args, source)...)
+		cmd.Stdout = out
+		return cmd.Run()
+	}
+}
+
+// compress_best compresses path with every available compressor, keeps the
+// smallest result and removes path and the other candidates. The winner is
+// recorded by the extension of the returned file.
+func compress_best(task BackupTask, path string) (string, error) {
+	best := ""
+	var best_size int64
+	var last_err error
+	for _, c := range best_compressors {
+		target := path + c.ext
+		run := c.run
+		if c.name == "gzip" {
+			run = gzip_compressor(pgzip.BestCompression, task.GzipBlockSize, task.GzipWorkers)
+		}
+		if err := run(path, target); err != nil {
+			log.Printf("Compressing %s with %s failed: %v", filepath.Base(path), c.name, err)
+			os.Remove(target)
+			last_err = err
+			continue
+		}
+		info, err := os.Stat(target)
+		if err != nil {
+			os.Remove(target)
+			last_err = err
+			continue
+		}
+		if best == "" || info.Size() < best_size {
+			if best != "" {
+				os.Remove(best)
+			}
+			best, best_size = target, info.Size()
+		} else {
+			os.Remove(target)
+		}
+	}
+	if best == "" {
+		return "", last_err
+	}
+	log.Printf("Best compression for %s: %s (%d bytes)", filepath.Base(final_name(path)), filepath.Ext(best), best_size)
+	return best, os.Remove(path)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGzipCompressor(t *testing.T) {
+	tests := []struct {
+		name       string
+		block_size int
+		workers    int
+	}{
+		{"defaults", 0, 0},
+		{"small blocks on one core", 32768, 1},
+		{"small blocks on four cores", 32768, 4},
+	}
+	data := make([]byte, 300000)
+	rand.New(rand.NewSource(1)).Read(data[:100000])
+	copy(data[100000:], bytes.Repeat([]byte("CREATE TABLE t (id int);\n"), 8000))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, "shop.sql")
+			os.WriteFile(source, data, 0644)
+			target := source + ".gz"
+			if err := gzip_compressor(gzip.BestCompression, tt.block_size, tt.workers)(source, target); err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.Open(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			// pgzip writes one standard stream that plain gzip reads.
+			reader, err := gzip.NewReader(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("decompressed %d bytes, want the %d written", len(got), len(data))
+			}
+		})
+	}
+}
+
+// fixed_compressor writes size bytes, or fails when size is negative.
+func fixed_compressor(size int) func(source, target string) error {
+	return func(source, target string) error {
+		if size < 0 {
+			return os.ErrInvalid
+		}
+		return os.WriteFile(target, make([]byte, size), 0644)
+	}
+}
+
+func TestCompressBest(t *testing.T) {
+	tests := []struct {
+		name  string
+		sizes []int // of the .a, .b and .c candidates; negative fails
+		want  string
+	}{
+		{"smallest wins", []int{30, 10, 20}, ".b"},
+		{"ties keep the first", []int{10, 10, 20}, ".a"},
+		{"failures are skipped", []int{-1, 20, -1}, ".b"},
+		{"all fail", []int{-1, -1, -1}, ""},
+	}
+	defer func(saved []compressor) { best_compressors = saved }(best_compressors)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			best_compressors = nil
+			for i, ext := range []string{".a", ".b", ".c"} {
+				best_compressors = append(best_compressors, compressor{ext[1:], ext, fixed_compressor(tt.sizes[i])})
+			}
+			dir := t.TempDir()
+			path := filepath.Join(dir, "shop.sql")
+			os.WriteFile(path, []byte("CREATE TABLE t (id int);\n"), 0644)
+
+			best, err := compress_best(BackupTask{}, path)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("compress_best = %s, want an error", best)
+				}
+				if _, err := os.Stat(path); err != nil {
+					t.Errorf("dump removed although nothing compressed it: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if best != path+tt.want {
+				t.Errorf("compress_best = %s, want %s", best, path+tt.want)
+			}
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != 1 || entries[0].Name() != filepath.Base(best) {
+				t.Errorf("left %v, want only %s", entries, filepath.Base(best))
+			}
+		})
+	}
+}
+
+// BenchmarkGzipCompressor compares pgzip on one core with pgzip on every
+// core, over a dump of mixed random and repetitive data.
+func BenchmarkGzipCompressor(b *testing.B) {
+	data := make([]byte, 16<<20)
+	rand.New(rand.NewSource(1)).Read(data[:4<<20])
+	copy(data[4<<20:], bytes.Repeat([]byte("INSERT INTO t VALUES (1, 'row');\n"), 12<<20/33))
+	dir := b.TempDir()
+	source := filepath.Join(dir, "shop.sql")
+	os.WriteFile(source, data, 0644)
+	for _, bench := range []struct {
+		name    string
+		workers int
+	}{
+		{"workers=1", 1},
+		{"workers=default", 0},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			compress := gzip_compressor(gzip.DefaultCompression, 0, bench.workers)
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if err := compress(source, source+".gz"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
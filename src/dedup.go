@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+)
+
+// runDedup remembers the content of every backup written in this run, so a
+// task producing the same content as an earlier one can drop its copy.
+type runDedup struct {
+	mu   sync.Mutex
+	seen map[string]string // content digest -> task that wrote it
+}
+
+// run_dedup is set when DedupIdentical is on.
+var run_dedup *runDedup
+
+// backup_digest hashes the files of one backup in order.
+func backup_digest(files []string) (string, error) {
+	digest := sha256.New()
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			return "", err
+		}
+		hash := sha256.New()
+		_, err = io.Copy(hash, file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+		digest.Write(hash.Sum(nil))
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// claim records the backup of task and returns the task that already wrote
+// identical content in this run, if any.
+func (d *runDedup) claim(task string, files []string) (string, bool) {
+	digest, err := backup_digest(files)
+	if err != nil {
+		return "", false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if original, ok := d.seen[digest]; ok {
+		return original, true
+	}
+	d.seen[digest] = task
+	return "", false
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunDedup(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(data), 0644)
+		return path
+	}
+	a1, a2 := write("a.part001.zip", "one"), write("a.part002.zip", "two")
+	b1, b2 := write("b.part001.zip", "one"), write("b.part002.zip", "two")
+	single := write("c.zip", "onetwo")
+	other := write("d.zip", "other")
+
+	dedup := &runDedup{seen: map[string]string{}}
+	tests := []struct {
+		task     string
+		files    []string
+		original string
+	}{
+		{"a", []string{a1, a2}, ""},
+		{"b", []string{b1, b2}, "a"},
+		{"reordered", []string{b2, b1}, ""},
+		{"concatenated", []string{single}, ""},
+		{"other", []string{other}, ""},
+		{"again", []string{other}, "other"},
+		{"missing", []string{filepath.Join(dir, "missing.zip")}, ""},
+		{"missing again", []string{filepath.Join(dir, "missing.zip")}, ""},
+	}
+	for _, tt := range tests {
+		original, ok := dedup.claim(tt.task, tt.files)
+		if original != tt.original || ok != (tt.original != "") {
+			t.Errorf("claim(%s) = %q, %v, want %q", tt.task, original, ok, tt.original)
+		}
+	}
+}
+
+// Two tasks backing up the same source in one run: the second task's
+// archive is dropped and only the first reaches the remote.
+func TestDedupIdentical(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "nginx")
+	write_tree(t, source, map[string]string{"nginx.conf": "worker_processes 4;\n", "sites/shop.conf": "listen 443;\n"})
+	rclone := fake_rclone(t, dir)
+	saved := run_dedup
+	run_dedup = &runDedup{seen: map[string]string{}}
+	defer func() { run_dedup = saved }()
+
+	stores := map[string]string{}
+	for _, name := range []string{"web", "proxy"} {
+		store := filepath.Join(dir, name)
+		os.MkdirAll(store, 0755)
+		stores[name] = store
+		task := BackupTask{Name: name, BackupSource: source, StorePath: store, RemotePath: "r:x", RclonePath: rclone, MaxBackup: 5}
+		if err := handle_task(task, "", 0, false, backup_config); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if got := remaining(stores["web"]); len(got) != 1 || !strings.HasPrefix(got[0], "web-") {
+		t.Errorf("web kept %v, want its archive", got)
+	}
+	if got := remaining(stores["proxy"]); len(got) != 0 {
+		t.Errorf("proxy kept %v, want its identical archive removed", got)
+	}
+	if got := remaining(filepath.Join(dir, "remote")); len(got) != 1 || got[0] != remaining(stores["web"])[0] {
+		t.Errorf("remote holds %v, want only web's archive", got)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// apply_defaults resolves every task in data, the raw config, against the
+// config's Defaults block: a task starts from the defaults, and each field
+// it sets itself overrides them.
+func apply_defaults(data []byte, config *Config) error {
+	if config.Defaults == nil {
+		return nil
+	}
+	if config.Defaults.Website != "" || config.Defaults.Database != "" || config.Defaults.Name != "" {
+		return fmt.Errorf("Defaults cannot set Website, Database or Name")
+	}
+
+	var raw struct {
+		Defaults           json.RawMessage   `json:"Defaults"`
+		WebsiteTasks       []json.RawMessage `json:"WebsiteTasks"`
+		DatabaseTasks      []json.RawMessage `json:"DatabaseTasks"`
+		ConfigTasks        []json.RawMessage `json:"ConfigTasks"`
+		ElasticsearchTasks []json.RawMessage `json:"ElasticsearchTasks"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for _, group := range []struct {
+		raw   []json.RawMessage
+		tasks []BackupTask
+	}{
+		{raw.WebsiteTasks, config.WebsiteTasks},
+		{raw.DatabaseTasks, config.DatabaseTasks},
+		{raw.ConfigTasks, config.ConfigTasks},
+		{raw.ElasticsearchTasks, config.ElasticsearchTasks},
+	} {
+		for i, task_data := range group.raw {
+			// Unmarshal the defaults afresh for each task, so pointer
+			// fields such as GFS are not shared between tasks.
+			var task BackupTask
+			if err := json.Unmarshal(raw.Defaults, &task); err != nil {
+				return err
+			}
+			if err := json.Unmarshal(task_data, &task); err != nil {
+				return err
+			}
+			// A task's own GFS block replaces the default one rather than
+			// merging tier by tier, and its deprecated OnedrivePath beats
+			// a default RemotePath.
+			own := group.tasks[i]
+			if own.GFS != nil {
+				task.GFS = own.GFS
+			}
+			if own.RemotePath == "" && own.OnedrivePath != "" {
+				task.RemotePath = own.OnedrivePath
+			}
+			group.tasks[i] = task
+		}
+	}
+	return nil
+}
+
+// print_effective_config prints every task as it will run, after Defaults
+// and the deprecated aliases have been resolved.
+func print_effective_config(config Config) error {
+	data, err := json.MarshalIndent(struct {
+		WebsiteTasks       []BackupTask `json:"WebsiteTasks"`
+		DatabaseTasks      []BackupTask `json:"DatabaseTasks"`
+		ConfigTasks        []BackupTask `json:"ConfigTasks"`
+		ElasticsearchTasks []BackupTask `json:"ElasticsearchTasks,omitempty"`
+	}{config.WebsiteTasks, config.DatabaseTasks, config.ConfigTasks, config.ElasticsearchTasks}, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
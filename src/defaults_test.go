@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestApplyDefaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		check  func(tasks []BackupTask) bool
+		fails  bool
+	}{
+		{"no Defaults", `{"WebsiteTasks": [{"Website": "site", "MaxBackup": 3}]}`,
+			func(tasks []BackupTask) bool { return tasks[0].MaxBackup == 3 && tasks[0].StorePath == "" }, false},
+		{"inherited", `{"Defaults": {"StorePath": "/srv/backup", "MaxBackup": 7}, "WebsiteTasks": [{"Website": "site"}]}`,
+			func(tasks []BackupTask) bool { return tasks[0].StorePath == "/srv/backup" && tasks[0].MaxBackup == 7 }, false},
+		{"overridden", `{"Defaults": {"StorePath": "/srv/backup", "MaxBackup": 7}, "WebsiteTasks": [{"Website": "site", "MaxBackup": 3}]}`,
+			func(tasks []BackupTask) bool { return tasks[0].StorePath == "/srv/backup" && tasks[0].MaxBackup == 3 }, false},
+		{"overridden with a zero value", `{"Defaults": {"FileList": true}, "WebsiteTasks": [{"Website": "site", "FileList": false}, {"Website": "blog"}]}`,
+			func(tasks []BackupTask) bool { return !tasks[0].FileList && tasks[1].FileList }, false},
+		{"own GFS replaces the default", `{"Defaults": {"GFS": {"Daily": 7, "Weekly": 4}}, "WebsiteTasks": [{"Website": "site", "GFS": {"Monthly": 12}}, {"Website": "blog"}]}`,
+			func(tasks []BackupTask) bool {
+				return *tasks[0].GFS == GFSRetention{Monthly: 12} && *tasks[1].GFS == GFSRetention{Daily: 7, Weekly: 4} && tasks[0].GFS != tasks[1].GFS
+			}, false},
+		{"OnedrivePath beats a default RemotePath", `{"Defaults": {"RemotePath": "remote:default"}, "WebsiteTasks": [{"Website": "site", "OnedrivePath": "remote:site"}]}`,
+			func(tasks []BackupTask) bool { return tasks[0].RemotePath == "remote:site" }, false},
+		{"Defaults naming a task", `{"Defaults": {"Website": "site"}, "WebsiteTasks": [{"Website": "blog"}]}`, nil, true},
+	}
+	for _, tt := range tests {
+		var config Config
+		if err := json.Unmarshal([]byte(tt.config), &config); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		err := apply_defaults([]byte(tt.config), &config)
+		if (err != nil) != tt.fails {
+			t.Errorf("%s: apply_defaults = %v, want failure %v", tt.name, err, tt.fails)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		normalize_config(&config)
+		if !tt.check(config.WebsiteTasks) {
+			data, _ := json.Marshal(config.WebsiteTasks)
+			t.Errorf("%s: tasks resolved to %s", tt.name, data)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	delta_state_file   = ".goBack-delta.json"
+	delta_marker       = ".delta"
+	delta_deleted_name = "goBack-deleted.txt"
+
+	default_delta_full_every = 7
+)
+
+type deltaFile struct {
+	Size    int64     `json:"Size"`
+	ModTime time.Time `json:"ModTime"`
+	Dir     bool      `json:"Dir,omitempty"`
+}
+
+func (f deltaFile) same(other deltaFile) bool {
+	return f.Size == other.Size && f.ModTime.Equal(other.ModTime) && f.Dir == other.Dir
+}
+
+// deltaState is the chain manifest kept in StorePath: the baseline, the
+// deltas written on top of it and the source tree as of the last run.
+type deltaState struct {
+	Baseline string               `json:"Baseline"`
+	Deltas   []string             `json:"Deltas"`
+	Files    map[string]deltaFile `json:"Files"`
+	Runs     []deltaRun           `json:"Runs"`
+}
+
+// deltaRun reports what a run saved compared to a full backup: the files
+// left out because they were unchanged, and their total size.
+type deltaRun struct {
+	Archive      string `json:"Archive"`
+	SkippedFiles int    `json:"SkippedFiles"`
+	SavedBytes   int64  `json:"SavedBytes"`
+}
+
+func load_delta_state(store_path string) *deltaState {
+	data, err := os.ReadFile(filepath.Join(store_path, delta_state_file))
+	if err != nil {
+		return nil
+	}
+	var state deltaState
+	if json.Unmarshal(data, &state) != nil {
+		return nil
+	}
+	return &state
+}
+
+func save_delta_state(store_path string, state *deltaState) error {
+	data, err := json.MarshalIndent(state, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(store_path, delta_state_file), data, 0644)
+}
+
+// delta_needs_baseline reports whether the next run must start a new chain.
+func delta_needs_baseline(task BackupTask, state *deltaState) bool {
+	if state == nil || state.Baseline == "" {
+		return true
+	}
+	if _, err := os.Stat(filepath.Join(task.StorePath, state.Baseline)); err != nil {
+		return true
+	}
+	full_every := task.DeltaFullEvery
+	if full_every <= 0 {
+		full_every = default_delta_full_every
+	}
+	return len(state.Deltas)+1 >= full_every
+}
+
+// createDeltaZip writes either a full baseline to target or, when the chain
+// allows, a delta next to it holding only files that are new or changed
+// since the last run plus a list of removed paths. It returns the file
+// written.
+func createDeltaZip(task BackupTask, target string) (string, error) {
+	state := load_delta_state(task.StorePath)
+	baseline := delta_needs_baseline(task, state)
+	if baseline {
+		state = &deltaState{}
+	} else {
+		ext := archive_extension(task)
+		target = strings.TrimSuffix(target, ext) + delta_marker + ext
+	}
+
+	zipfile, err := os.Create(target)
+	if err != nil {
+		return target, err
+	}
+	defer zipfile.Close()
+
+	archive := zip.NewWriter(zipfile)
+	defer archive.Close()
+
+	list := new_file_list(task.FileList)
+	current := map[string]deltaFile{}
+	run := deltaRun{Archive: filepath.Base(final_name(target))}
+	var open map[string]bool
+	if task.SkipOpenFiles {
+		open = files_open_for_writing()
+	}
+	// Open files are skipped here rather than by source_entries, so they
+	// keep their last recorded state instead of being listed as deleted.
+	walk := task
+	walk.SkipOpenFiles = false
+	entries, err := source_entries(walk, filepath.Dir(target))
+	if err != nil {
+		return target, err
+	}
+	for _, source_entry := range entries {
+		name, info := source_entry.name, source_entry.info
+		if is_open(open, source_entry.path) {
+			log.Printf("Skipping %s in %s: open for writing by another process", source_entry.path, task_name(task))
+			if previous, ok := state.Files[name]; ok {
+				current[name] = previous
+			}
+			continue
+		}
+		entry := deltaFile{Size: info.Size(), ModTime: info.ModTime(), Dir: info.IsDir()}
+		if entry.Dir {
+			entry.Size, entry.ModTime = 0, time.Time{}
+		}
+		current[name] = entry
+		if previous, ok := state.Files[name]; ok && previous.same(entry) {
+			if !entry.Dir {
+				run.SkippedFiles++
+				run.SavedBytes += entry.Size
+			}
+			continue
+		}
+		if err := add_zip_entry_named(archive, name, source_entry.path, info, list); err != nil {
+			return target, err
+		}
+	}
+
+	if !baseline {
+		var deleted []string
+		for name := range state.Files {
+			if _, ok := current[name]; !ok {
+				deleted = append(deleted, name)
+			}
+		}
+		sort.Strings(deleted)
+		writer, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     delta_deleted_name,
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+		})
+		if err != nil {
+			return target, err
+		}
+		if _, err := writer.Write([]byte(strings.Join(deleted, "\n"))); err != nil {
+			return target, err
+		}
+	}
+
+	if err := finish_zip(archive, task, target, list); err != nil {
+		return target, err
+	}
+
+	if baseline {
+		state.Baseline = run.Archive
+		state.Deltas = nil
+		state.Runs = nil
+	} else {
+		state.Deltas = append(state.Deltas, run.Archive)
+		log.Printf("Delta %s saved %s over a full backup (%d unchanged files)", run.Archive, format_bytes(uint64(run.SavedBytes)), run.SkippedFiles)
+	}
+	state.Runs = append(state.Runs, run)
+	state.Files = current
+	return target, save_delta_state(task.StorePath, state)
+}
+
+// notify_delta_savings reports the savings of the run just written.
+func notify_delta_savings(task BackupTask, name, botToken string, chatID int64, enable bool) {
+	state := load_delta_state(task.StorePath)
+	if state == nil || len(state.Runs) == 0 {
+		return
+	}
+	run := state.Runs[len(state.Runs)-1]
+	if run.Archive == state.Baseline {
+		return
+	}
+	send_message(botToken, chatID, fmt.Sprintf("Backup savings for %s: %s saved, %d unchanged files skipped", name, format_bytes(uint64(run.SavedBytes)), run.SkippedFiles), enable)
+}
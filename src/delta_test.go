@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tree_contents returns every file below dir as "path=content".
+func tree_contents(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, _ := os.ReadFile(path)
+		rel, _ := filepath.Rel(dir, path)
+		files = append(files, rel+"="+string(data))
+		return nil
+	})
+	sort.Strings(files)
+	return files
+}
+
+// A delta chain restores the source as it was at each run: changes,
+// additions and removals are all replayed.
+func TestDeltaChainRestore(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "site")
+	store := filepath.Join(dir, "store")
+	os.MkdirAll(filepath.Join(source, "css"), 0755)
+	os.MkdirAll(store, 0755)
+	task := BackupTask{Website: "site", BackupSource: source, StorePath: store, DeltaMode: true}
+	write := func(name, data string, age time.Duration) {
+		path := filepath.Join(source, name)
+		os.WriteFile(path, []byte(data), 0644)
+		modified := time.Now().Add(-age)
+		os.Chtimes(path, modified, modified)
+	}
+
+	runs := []struct {
+		change func()
+		name   string // of the archive written
+		want   []string
+	}{
+		{func() {
+			write("index.html", "v1", 3*time.Hour)
+			write("css/site.css", "body {}", 3*time.Hour)
+			write("old.html", "old", 3*time.Hour)
+		}, "site-20261014-100000.zip", []string{"site/css/site.css=body {}", "site/index.html=v1", "site/old.html=old"}},
+		{func() {
+			write("index.html", "v2", 2*time.Hour)
+			write("new.html", "new", 2*time.Hour)
+		}, "site-20261014-110000.delta.zip", []string{"site/css/site.css=body {}", "site/index.html=v2", "site/new.html=new", "site/old.html=old"}},
+		{func() {
+			os.Remove(filepath.Join(source, "old.html"))
+			write("css/site.css", "body { margin: 0 }", time.Hour)
+		}, "site-20261014-120000.delta.zip", []string{"site/css/site.css=body { margin: 0 }", "site/index.html=v2", "site/new.html=new"}},
+	}
+	var archives []string
+	for i, run := range runs {
+		run.change()
+		files, err := archive_source(task, filepath.Join(store, fmt.Sprintf("site-20261014-%d0000.zip", 10+i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Base(files[0]) != run.name {
+			t.Fatalf("run %d wrote %s, want %s", i+1, filepath.Base(files[0]), run.name)
+		}
+		archives = append(archives, files[0])
+	}
+	state := load_delta_state(store)
+	if state.Baseline != runs[0].name || strings.Join(state.Deltas, ",") != runs[1].name+","+runs[2].name {
+		t.Errorf("delta state = %s + %v", state.Baseline, state.Deltas)
+	}
+
+	for i, archive := range archives {
+		dest := filepath.Join(dir, "restored", filepath.Base(archive))
+		if err := restore_backup(archive, dest, ""); err != nil {
+			t.Fatalf("restoring %s: %v", filepath.Base(archive), err)
+		}
+		if got := tree_contents(t, dest); strings.Join(got, ",") != strings.Join(runs[i].want, ",") {
+			t.Errorf("%s restored %v, want %v", filepath.Base(archive), got, runs[i].want)
+		}
+	}
+}
+
+func TestDeltaFullEvery(t *testing.T) {
+	tests := []struct {
+		full_every int
+		want       []string // the kind of each run's archive
+	}{
+		{0, []string{"full", "delta", "delta", "delta", "delta", "delta", "delta", "full"}},
+		{3, []string{"full", "delta", "delta", "full", "delta"}},
+		{1, []string{"full", "full", "full"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.want, ","), func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, "site")
+			store := filepath.Join(dir, "store")
+			os.MkdirAll(source, 0755)
+			os.MkdirAll(store, 0755)
+			os.WriteFile(filepath.Join(source, "index.html"), []byte("<html>"), 0644)
+			task := BackupTask{Website: "site", BackupSource: source, StorePath: store, DeltaMode: true, DeltaFullEvery: tt.full_every}
+			for i, kind := range tt.want {
+				files, err := archive_source(task, filepath.Join(store, fmt.Sprintf("site-20261014-10%02d00.zip", i)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := map[bool]string{true: "delta", false: "full"}[is_delta_name(filepath.Base(files[0]))]; got != kind {
+					t.Errorf("run %d wrote a %s archive %s, want %s", i+1, got, filepath.Base(files[0]), kind)
+				}
+			}
+		})
+	}
+}
+
+func TestDeltaRotationKeepsChains(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		"site-20261011-100000.zip",
+		"site-20261012-100000.delta.zip",
+		"site-20261013-100000.zip",
+		"site-20261014-100000.delta.zip",
+		"site-20261015-100000.delta.zip",
+	}
+	for i, name := range names {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(name), 0644)
+		modified := time.Date(2026, 10, 11+i, 10, 0, 0, 0, time.UTC)
+		os.Chtimes(path, modified, modified)
+	}
+	task := BackupTask{Website: "site", StorePath: dir, DeltaMode: true, MaxBackup: 1}
+	sets := backup_sets(task)
+	if len(sets) != 2 || len(sets[0].files) != 2 || len(sets[1].files) != 3 {
+		t.Fatalf("sets = %v, want the two chains", sets)
+	}
+	check_backup_file_num(task)
+	if got := strings.Join(remaining(dir), ","); got != strings.Join(names[2:], ",") {
+		t.Errorf("kept %s, want the newest chain", got)
+	}
+}
+
+func TestDeltaSavings(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "site")
+	store := filepath.Join(dir, "store")
+	os.MkdirAll(filepath.Join(source, "img"), 0755)
+	os.MkdirAll(store, 0755)
+	old := time.Now().Add(-time.Hour)
+	for name, size := range map[string]int{"img/logo.png": 5000, "img/hero.jpg": 20000, "index.html": 100} {
+		path := filepath.Join(source, name)
+		os.WriteFile(path, make([]byte, size), 0644)
+		os.Chtimes(path, old, old)
+	}
+	task := BackupTask{Website: "site", BackupSource: source, StorePath: store, DeltaMode: true, NotifySavings: true}
+
+	tests := []struct {
+		change  func()
+		skipped int
+		saved   int64
+	}{
+		{func() {}, 0, 0},
+		{func() { os.WriteFile(filepath.Join(source, "index.html"), []byte("changed"), 0644) }, 2, 25000},
+		{func() {}, 3, 25007},
+	}
+	for i, tt := range tests {
+		tt.change()
+		if _, err := archive_source(task, filepath.Join(store, fmt.Sprintf("site-20261014-%d0000.zip", 10+i))); err != nil {
+			t.Fatal(err)
+		}
+		state := load_delta_state(store)
+		run := state.Runs[len(state.Runs)-1]
+		if run.SkippedFiles != tt.skipped || run.SavedBytes != tt.saved {
+			t.Errorf("run %d saved %d bytes in %d files, want %d in %d", i+1, run.SavedBytes, run.SkippedFiles, tt.saved, tt.skipped)
+		}
+		if len(state.Runs) != i+1 {
+			t.Errorf("run %d: %d runs recorded, want %d", i+1, len(state.Runs), i+1)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+const default_device_block_size = 64 * 1024
+
+// errEndOfMedia is returned when the device reports it has no room left.
+var errEndOfMedia = errors.New("end of media")
+
+// write_to_device streams files to device one after another in fixed-size
+// blocks. Only the final block of the stream may be short.
+func write_to_device(task BackupTask, files []string) error {
+	device, block_size := task.DevicePath, task.DeviceBlockSize
+	if block_size <= 0 {
+		block_size = default_device_block_size
+	}
+	dev, err := os.OpenFile(device, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+
+	var readers []io.Reader
+	var total int64
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if info, err := file.Stat(); err == nil {
+			total += info.Size()
+		}
+		readers = append(readers, file)
+	}
+
+	reader := with_progress(task, io.MultiReader(readers...), total, device)
+	if err := write_blocks(dev, reader, block_size); err != nil {
+		return device_error(err)
+	}
+	return dev.Sync()
+}
+
+// write_blocks copies reader to dev in writes of exactly block_size bytes,
+// as a fixed-block tape needs; only the last write may be shorter.
+// io.Copy would hand the copy to the file's ReadFrom, whose writes follow
+// the reads instead.
+func write_blocks(dev io.Writer, reader io.Reader, block_size int) error {
+	block := make([]byte, block_size)
+	for {
+		n, err := io.ReadFull(reader, block)
+		if n > 0 {
+			if _, err := dev.Write(block[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func device_error(err error) error {
+	if errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EIO) || errors.Is(err, io.ErrShortWrite) {
+		return errEndOfMedia
+	}
+	return err
+}
+
+func copy_backup_to_device(task BackupTask, files []string, botToken string, chatID int64, enable bool) error {
+	err := write_to_device(task, files)
+	if errors.Is(err, errEndOfMedia) {
+		send_message(botToken, chatID, "Device Backup FAILED: end of media on "+task.DevicePath+", load a new tape/volume", enable)
+	} else if err != nil {
+		send_message(botToken, chatID, "Device Backup FAILED: "+task.DevicePath, enable)
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// recordingWriter keeps the size of every write, as a tape sees them.
+type recordingWriter struct {
+	bytes.Buffer
+	writes []int
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, len(p))
+	return w.Buffer.Write(p)
+}
+
+func TestWriteBlocks(t *testing.T) {
+	tests := []struct {
+		name   string
+		sizes  []int // sizes of the files streamed one after another
+		block  int
+		writes []int
+	}{
+		{"two files across blocks", []int{100000, 100000}, 65536, []int{65536, 65536, 65536, 3392}},
+		{"exact blocks", []int{8192, 8192}, 8192, []int{8192, 8192}},
+		{"shorter than a block", []int{100}, 65536, []int{100}},
+		{"empty", []int{0}, 65536, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var readers []io.Reader
+			var want []byte
+			for i, size := range tt.sizes {
+				data := bytes.Repeat([]byte{byte('a' + i)}, size)
+				want = append(want, data...)
+				// One byte per read, the worst case for keeping blocks whole.
+				readers = append(readers, iotest.OneByteReader(bytes.NewReader(data)))
+			}
+			var dev recordingWriter
+			if err := write_blocks(&dev, io.MultiReader(readers...), tt.block); err != nil {
+				t.Fatal(err)
+			}
+			if len(dev.writes) != len(tt.writes) {
+				t.Fatalf("writes = %v, want %v", dev.writes, tt.writes)
+			}
+			for i := range tt.writes {
+				if dev.writes[i] != tt.writes[i] {
+					t.Fatalf("writes = %v, want %v", dev.writes, tt.writes)
+				}
+			}
+			if !bytes.Equal(dev.Bytes(), want) {
+				t.Error("device content differs from the files")
+			}
+		})
+	}
+}
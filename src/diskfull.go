@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// is_disk_full reports whether err, or the output of the command that
+// produced it, shows the filesystem ran out of space.
+func is_disk_full(err error, output string) bool {
+	return errors.Is(err, syscall.ENOSPC) || strings.Contains(output, "No space left on device")
+}
+
+// free_space returns the bytes available to unprivileged users on the
+// filesystem holding path.
+func free_space(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// free_inodes returns the free and total inodes of the filesystem holding
+// path. Filesystems without a fixed inode table report a total of 0. Tests
+// replace it to simulate exhausted filesystems.
+var free_inodes = func(path string) (uint64, uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	return stat.Ffree, stat.Files, nil
+}
+
+// min_free_inodes is the headroom kept for the archive, its sidecars and
+// temporary files, on top of one inode per ArchiveWorkers temp zip.
+const min_free_inodes = 64
+
+// check_inodes fails the task up front when StorePath is nearly out of
+// inodes, where creating the archive would otherwise fail with an opaque
+// error part way through.
+func check_inodes(task BackupTask, botToken string, chatID int64, enable bool) error {
+	free, total, err := free_inodes(task.StorePath)
+	if err != nil || total == 0 {
+		return nil
+	}
+	if free >= uint64(min_free_inodes+task.ArchiveWorkers) {
+		return nil
+	}
+	log.Printf("Skipping %s: inodes exhausted on %s (%d of %d free)", task_name(task), task.StorePath, free, total)
+	send_message(botToken, chatID, fmt.Sprintf("Backup FAILED: inodes exhausted on %s (%d free)", task.StorePath, free), enable)
+	return fmt.Errorf("inodes exhausted on %s: %d of %d free", task.StorePath, free, total)
+}
+
+func format_bytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// report_disk_full removes the partial files of a backup that ran out of
+// space and sends an alert naming the full StorePath.
+func report_disk_full(task BackupTask, files []string, botToken string, chatID int64, enable bool) {
+	for _, name := range files {
+		os.Remove(name)
+		os.Remove(name + file_list_suffix)
+	}
+	free := "unknown"
+	if n, err := free_space(task.StorePath); err == nil {
+		free = format_bytes(n)
+	}
+	send_message(botToken, chatID, "Backup FAILED: disk full on "+task.StorePath+" ("+free+" free)", enable)
+}
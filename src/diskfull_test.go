@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestIsDiskFull(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		output string
+		full   bool
+	}{
+		{"ENOSPC", syscall.ENOSPC, "", true},
+		{"wrapped ENOSPC", fmt.Errorf("archiving: %w", &os.PathError{Op: "write", Path: "/srv/backup/a.zip", Err: syscall.ENOSPC}), "", true},
+		{"command output", errors.New("exit status 2"), "mysqldump: Error 3 on write: No space left on device", true},
+		{"other error", &os.PathError{Op: "open", Path: "/srv/site", Err: syscall.EACCES}, "", false},
+		{"no error", nil, "", false},
+	}
+	for _, tt := range tests {
+		if got := is_disk_full(tt.err, tt.output); got != tt.full {
+			t.Errorf("%s: is_disk_full = %v, want %v", tt.name, got, tt.full)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{45<<30 + 200<<20, "45.2 GiB"},
+	}
+	for _, tt := range tests {
+		if got := format_bytes(tt.n); got != tt.want {
+			t.Errorf("format_bytes(%d) = %s, want %s", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestReportDiskFull(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "site-20261014-100000.part001.zip"), filepath.Join(dir, "site-20261014-100000.part002.zip")}
+	for _, name := range files {
+		os.WriteFile(name, []byte("partial"), 0644)
+		os.WriteFile(name+file_list_suffix, []byte("list"), 0644)
+	}
+	keep := filepath.Join(dir, "site-20261013-100000.zip")
+	os.WriteFile(keep, []byte("previous"), 0644)
+
+	report_disk_full(BackupTask{Website: "site", StorePath: dir}, files, "", 0, false)
+	if got := remaining(dir); len(got) != 1 || got[0] != filepath.Base(keep) {
+		t.Errorf("left %v, want only the previous backup", got)
+	}
+}
+
+func TestCheckInodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		free    uint64
+		total   uint64
+		err     error
+		workers int
+		fails   bool
+	}{
+		{"plenty", 100000, 655360, nil, 0, false},
+		{"exhausted", 10, 655360, nil, 0, true},
+		{"headroom", min_free_inodes, 655360, nil, 0, false},
+		{"headroom for workers", min_free_inodes, 655360, nil, 4, true},
+		{"no inode table", 0, 0, nil, 0, false},
+		{"statfs fails", 0, 0, syscall.ENOENT, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := record_messages(t)
+			saved := free_inodes
+			free_inodes = func(string) (uint64, uint64, error) { return tt.free, tt.total, tt.err }
+			defer func() { free_inodes = saved }()
+
+			err := check_inodes(BackupTask{Website: "site", StorePath: "/srv/backup", ArchiveWorkers: tt.workers}, "token", 1, true)
+			if (err != nil) != tt.fails {
+				t.Fatalf("check_inodes = %v, want failure %v", err, tt.fails)
+			}
+			want := ""
+			if tt.fails {
+				want = fmt.Sprintf("Backup FAILED: inodes exhausted on /srv/backup (%d free)", tt.free)
+			}
+			if got := strings.Join(bot.messages(), ","); got != want {
+				t.Errorf("sent %q, want %q", got, want)
+			}
+		})
+	}
+
+	// The task is skipped before anything is written.
+	dir := t.TempDir()
+	saved := free_inodes
+	free_inodes = func(string) (uint64, uint64, error) { return 1, 655360, nil }
+	defer func() { free_inodes = saved }()
+	ran := false
+	err := handle_task(BackupTask{Website: "site", StorePath: dir}, "", 0, false, func(BackupTask, string, int64, bool) ([]string, error) {
+		ran = true
+		return nil, nil
+	})
+	if err == nil || ran {
+		t.Errorf("handle_task = %v, backup ran %v; want it skipped", err, ran)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const disk_usage_state_file = ".goBack-disk-usage.json"
+
+// diskUsage is how full a StorePath's filesystem was at the end of a run.
+type diskUsage struct {
+	Used      uint64    `json:"Used"`
+	Available uint64    `json:"Available"`
+	Time      time.Time `json:"Time"`
+}
+
+// usage_from_statfs counts used space the way df does: reserved blocks are
+// neither used nor available, so a disk is 100% full once unprivileged
+// users cannot write to it.
+func usage_from_statfs(stat syscall.Statfs_t, now time.Time) diskUsage {
+	bsize := uint64(stat.Bsize)
+	return diskUsage{Used: (stat.Blocks - stat.Bfree) * bsize, Available: stat.Bavail * bsize, Time: now}
+}
+
+func (u diskUsage) percent() int {
+	total := u.Used + u.Available
+	if total == 0 {
+		return 0
+	}
+	// Rounded up, as df does.
+	return int((u.Used*100 + total - 1) / total)
+}
+
+// format_disk_usage is the summary line for a StorePath, e.g.
+// "/srv/backup: 45.2 GiB used, +1.2 GiB since last run, 73% full".
+// Without a previous run there is no change to show.
+func format_disk_usage(path string, current diskUsage, previous *diskUsage) string {
+	line := fmt.Sprintf("%s: %s used", path, format_bytes(current.Used))
+	if previous != nil {
+		change := "+" + format_bytes(current.Used-previous.Used)
+		if current.Used < previous.Used {
+			change = "-" + format_bytes(previous.Used-current.Used)
+		}
+		line += ", " + change + " since last run"
+	}
+	return line + fmt.Sprintf(", %d%% full", current.percent())
+}
+
+// store_paths returns each StorePath of the config once, in task order.
+func store_paths(config Config) []string {
+	seen := map[string]bool{}
+	var paths []string
+	for _, task := range all_tasks(config) {
+		if task.StorePath == "" || seen[task.StorePath] {
+			continue
+		}
+		seen[task.StorePath] = true
+		paths = append(paths, task.StorePath)
+	}
+	return paths
+}
+
+// disk_usage_lines measures every StorePath and records the figures for
+// the next run to compare with.
+func disk_usage_lines(config Config) []string {
+	var lines []string
+	for _, path := range store_paths(config) {
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(path, &stat); err != nil {
+			lines = append(lines, fmt.Sprintf("%s: disk usage unavailable: %v", path, err))
+			continue
+		}
+		current := usage_from_statfs(stat, time.Now())
+		state := filepath.Join(path, disk_usage_state_file)
+		var previous *diskUsage
+		if data, err := os.ReadFile(state); err == nil {
+			var last diskUsage
+			if json.Unmarshal(data, &last) == nil {
+				previous = &last
+			}
+		}
+		lines = append(lines, format_disk_usage(path, current, previous))
+		data, _ := json.Marshal(current)
+		if err := os.WriteFile(state, data, 0644); err != nil {
+			log.Printf("Error saving disk usage of %s: %v", path, err)
+		}
+	}
+	return lines
+}
+
+// report_summary sends the IncludeDiskUsage summary at the end of a run:
+// how many tasks succeeded and how full each StorePath is, with the change
+// since the previous run, as early warning before a disk fills up.
+func report_summary(config Config, failed, total int) {
+	message := fmt.Sprintf("Backup Summary: %d of %d tasks succeeded\n%s", total-failed, total, strings.Join(disk_usage_lines(config), "\n"))
+	log.Print(message)
+	send_message(config.Telegram.BotToken, config.Telegram.ChatID, message, config.Telegram.Enable)
+}
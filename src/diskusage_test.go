@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestUsageFromStatfs(t *testing.T) {
+	// 1000 blocks of 4 KiB: 600 used, 50 reserved, 350 available.
+	stat := syscall.Statfs_t{Bsize: 4096, Blocks: 1000, Bfree: 400, Bavail: 350}
+	usage := usage_from_statfs(stat, time.Now())
+	if usage.Used != 600*4096 || usage.Available != 350*4096 {
+		t.Errorf("usage = %+v", usage)
+	}
+	if got := usage.percent(); got != 64 {
+		t.Errorf("percent = %d, want 64 as df rounds 63.2 up", got)
+	}
+	if got := (diskUsage{}).percent(); got != 0 {
+		t.Errorf("empty filesystem percent = %d", got)
+	}
+}
+
+func TestFormatDiskUsage(t *testing.T) {
+	current := diskUsage{Used: 45<<30 + 200<<20, Available: 17 << 30}
+	tests := []struct {
+		previous *diskUsage
+		want     string
+	}{
+		{nil, "/srv/backup: 45.2 GiB used, 73% full"},
+		{&diskUsage{Used: 44 << 30}, "/srv/backup: 45.2 GiB used, +1.2 GiB since last run, 73% full"},
+		{&diskUsage{Used: 46 << 30}, "/srv/backup: 45.2 GiB used, -824.0 MiB since last run, 73% full"},
+		{&diskUsage{Used: current.Used}, "/srv/backup: 45.2 GiB used, +0 B since last run, 73% full"},
+	}
+	for _, tt := range tests {
+		if got := format_disk_usage("/srv/backup", current, tt.previous); got != tt.want {
+			t.Errorf("format_disk_usage = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestReportSummary(t *testing.T) {
+	bot := record_messages(t)
+	dir := t.TempDir()
+	store := filepath.Join(dir, "store")
+	os.MkdirAll(store, 0755)
+	missing := filepath.Join(dir, "missing")
+	config := Config{
+		Telegram:      Telegram{BotToken: "token", ChatID: 1, Enable: true},
+		WebsiteTasks:  []BackupTask{{Website: "site", StorePath: store}, {Website: "blog", StorePath: store}},
+		DatabaseTasks: []BackupTask{{Database: "shop", StorePath: missing}},
+	}
+	if got := strings.Join(store_paths(config), ","); got != store+","+missing {
+		t.Errorf("store_paths = %s", got)
+	}
+
+	for run := 0; run < 2; run++ {
+		report_summary(config, 1, 3)
+	}
+	messages := bot.messages()
+	if len(messages) != 2 {
+		t.Fatalf("sent %q", messages)
+	}
+	for run, message := range messages {
+		lines := strings.Split(message, "\n")
+		if len(lines) != 3 || lines[0] != "Backup Summary: 2 of 3 tasks succeeded" {
+			t.Fatalf("run %d sent %q", run+1, message)
+		}
+		// Only the second run has figures to compare with.
+		if got := strings.Contains(lines[1], "since last run"); got != (run == 1) || !strings.HasPrefix(lines[1], store+": ") {
+			t.Errorf("run %d: %q", run+1, lines[1])
+		}
+		if !strings.HasPrefix(lines[2], missing+": disk usage unavailable") {
+			t.Errorf("run %d: %q", run+1, lines[2])
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	drift_report_name = "goBack-drift.txt"
+
+	drift_notify_lines = 20
+)
+
+// driftReport lists how the source tree differs from the golden baseline,
+// by path relative to each root.
+type driftReport struct {
+	added    []string
+	removed  []string
+	modified []string
+}
+
+func (r *driftReport) empty() bool {
+	return len(r.added)+len(r.removed)+len(r.modified) == 0
+}
+
+func (r *driftReport) String() string {
+	var b strings.Builder
+	for _, group := range []struct {
+		mark  string
+		paths []string
+	}{{"A", r.added}, {"D", r.removed}, {"M", r.modified}} {
+		for _, path := range group.paths {
+			fmt.Fprintf(&b, "%s %s\n", group.mark, path)
+		}
+	}
+	return b.String()
+}
+
+// summary is the form used in notifications: the counts, then the first
+// max lines of the report.
+func (r *driftReport) summary(max int) string {
+	summary := fmt.Sprintf("%d added, %d removed, %d modified", len(r.added), len(r.removed), len(r.modified))
+	lines := strings.SplitAfter(r.String(), "\n")
+	lines = lines[:len(lines)-1]
+	if len(lines) > max {
+		lines = append(lines[:max], "...\n")
+	}
+	return summary + "\n" + strings.TrimSuffix(strings.Join(lines, ""), "\n")
+}
+
+// drift_files maps the relative path of every regular file and symlink
+// below root to its content hash, skipping anything under store_path.
+func drift_files(root, store_path string) (map[string][sha256.Size]byte, error) {
+	files := map[string][sha256.Size]byte{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if store_path != "" && path_within(path, store_path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		hash := sha256.New()
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			hash.Write([]byte(target))
+		} else if info.Mode().IsRegular() {
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(hash, file)
+			file.Close()
+			if err != nil {
+				return err
+			}
+		}
+		var sum [sha256.Size]byte
+		copy(sum[:], hash.Sum(nil))
+		files[rel] = sum
+		return nil
+	})
+	return files, err
+}
+
+// config_drift compares the task's BackupSource with its DriftBaseline
+// directory, a known-good copy of the same tree.
+func config_drift(task BackupTask) (*driftReport, error) {
+	baseline, err := drift_files(task.DriftBaseline, "")
+	if err != nil {
+		return nil, err
+	}
+	current, err := drift_files(task.BackupSource, task.StorePath)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &driftReport{}
+	for path, sum := range current {
+		old, ok := baseline[path]
+		if !ok {
+			report.added = append(report.added, path)
+		} else if old != sum {
+			report.modified = append(report.modified, path)
+		}
+	}
+	for path := range baseline {
+		if _, ok := current[path]; !ok {
+			report.removed = append(report.removed, path)
+		}
+	}
+	sort.Strings(report.added)
+	sort.Strings(report.removed)
+	sort.Strings(report.modified)
+	return report, nil
+}
+
+func add_drift_report(archive *zip.Writer, report *driftReport) error {
+	writer, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     drift_report_name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(writer, report.String())
+	return err
+}
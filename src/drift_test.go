@@ -0,0 +1,111 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// write_tree writes files, by path relative to root, below root.
+func write_tree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConfigDrift(t *testing.T) {
+	baseline := map[string]string{"nginx.conf": "worker_processes 4;", "sites/shop.conf": "listen 80;", "mime.types": "text/html html;"}
+	tests := []struct {
+		name    string
+		current map[string]string
+		want    string
+	}{
+		{"unchanged", baseline, ""},
+		{"drifted", map[string]string{"nginx.conf": "worker_processes 8;", "sites/shop.conf": "listen 80;", "sites/blog.conf": "listen 81;"},
+			"A sites/blog.conf\nD mime.types\nM nginx.conf\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			golden := filepath.Join(dir, "golden")
+			source := filepath.Join(dir, "nginx")
+			store := filepath.Join(source, "backups")
+			write_tree(t, golden, baseline)
+			write_tree(t, source, tt.current)
+			// Earlier backups under StorePath are not part of the tree.
+			write_tree(t, store, map[string]string{"nginx-20261013-100000.zip": "zip"})
+
+			report, err := config_drift(BackupTask{BackupSource: source, StorePath: store, DriftBaseline: golden})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := report.String(); got != tt.want {
+				t.Errorf("report:\n%s\nwant:\n%s", got, tt.want)
+			}
+			if report.empty() != (tt.want == "") {
+				t.Errorf("empty() = %v", report.empty())
+			}
+		})
+	}
+}
+
+func TestDriftSummary(t *testing.T) {
+	report := &driftReport{added: []string{"a.conf", "b.conf", "c.conf"}, modified: []string{"nginx.conf"}}
+	tests := []struct {
+		max  int
+		want string
+	}{
+		{20, "3 added, 0 removed, 1 modified\nA a.conf\nA b.conf\nA c.conf\nM nginx.conf"},
+		{2, "3 added, 0 removed, 1 modified\nA a.conf\nA b.conf\n..."},
+	}
+	for _, tt := range tests {
+		if got := report.summary(tt.max); got != tt.want {
+			t.Errorf("summary(%d) = %q, want %q", tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestBackupConfigDrift(t *testing.T) {
+	bot := record_messages(t)
+	dir := t.TempDir()
+	golden := filepath.Join(dir, "golden")
+	source := filepath.Join(dir, "nginx")
+	store := filepath.Join(dir, "store")
+	write_tree(t, golden, map[string]string{"nginx.conf": "worker_processes 4;"})
+	write_tree(t, source, map[string]string{"nginx.conf": "worker_processes 8;"})
+	os.MkdirAll(store, 0755)
+
+	task := BackupTask{Name: "nginx", BackupSource: source, StorePath: store, DriftBaseline: golden}
+	files, err := backup_config(task, "token", 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := zip.OpenReader(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	var stored string
+	for _, file := range reader.File {
+		if file.Name == drift_report_name {
+			rc, _ := file.Open()
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			stored = string(data)
+		}
+	}
+	if stored != "M nginx.conf\n" {
+		t.Errorf("archived drift report = %q", stored)
+	}
+	if got := bot.messages(); len(got) != 1 || !strings.HasPrefix(got[0], "Config drift on nginx: 0 added, 0 removed, 1 modified") {
+		t.Errorf("sent %q, want the drift summary", got)
+	}
+}
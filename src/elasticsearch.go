@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	snapshot_poll_interval = 5 * time.Second
+	snapshot_http_timeout  = 30 * time.Second
+)
+
+var snapshot_client = &http.Client{Timeout: snapshot_http_timeout}
+
+// snapshot_request sends a request to the task's cluster and decodes the
+// JSON reply into result when it is not nil.
+func snapshot_request(task BackupTask, method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(run_ctx, method, strings.TrimSuffix(task.Elasticsearch, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := snapshot_client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
+
+type snapshotInfo struct {
+	Snapshot string `json:"snapshot"`
+	State    string `json:"state"`
+}
+
+type snapshotList struct {
+	Snapshots []snapshotInfo `json:"snapshots"`
+}
+
+func snapshot_path(task BackupTask, name string) string {
+	return "/_snapshot/" + url.PathEscape(task.SnapshotRepository) + "/" + url.PathEscape(name)
+}
+
+// snapshot_prefix starts the name of every snapshot of the task. Snapshot
+// names must be lowercase.
+func snapshot_prefix(task BackupTask) string {
+	return strings.ToLower(task.Name) + "-"
+}
+
+// backup_elasticsearch starts a snapshot of the task's indices in its
+// registered repository and polls until the cluster reports it finished.
+// The snapshot lives in the repository, so no local files are written.
+func backup_elasticsearch(task BackupTask, botToken string, chatID int64, enable bool) ([]string, error) {
+	name := snapshot_prefix(task) + backup_timestamp(task, time.Now())
+	body := map[string]interface{}{"include_global_state": false}
+	if task.SnapshotIndices != "" {
+		body["indices"] = task.SnapshotIndices
+	}
+	err := snapshot_request(task, http.MethodPut, snapshot_path(task, name), body, nil)
+	for err == nil {
+		var list snapshotList
+		err = snapshot_request(task, http.MethodGet, snapshot_path(task, name), nil, &list)
+		if err != nil {
+			break
+		}
+		if len(list.Snapshots) != 1 {
+			err = fmt.Errorf("snapshot %s not found", name)
+			break
+		}
+		state := list.Snapshots[0].State
+		if state == "SUCCESS" {
+			log.Printf("Snapshot %s of %s finished", name, task.Name)
+			return nil, nil
+		}
+		if state != "IN_PROGRESS" && state != "STARTED" {
+			err = fmt.Errorf("snapshot %s ended in state %s", name, state)
+			break
+		}
+		select {
+		case <-time.After(snapshot_poll_interval):
+		case <-run_ctx.Done():
+			err = run_ctx.Err()
+		}
+	}
+	log.Printf("Error taking snapshot of %s: %v", task.Name, err)
+	send_message(botToken, chatID, "Elasticsearch Snapshot FAILED: "+task.Name, enable)
+	return nil, err
+}
+
+// prune_snapshots keeps the newest MaxBackup snapshots of the task in its
+// repository.
+func prune_snapshots(task BackupTask) error {
+	if safe_mode {
+		return nil
+	}
+	var list snapshotList
+	if err := snapshot_request(task, http.MethodGet, snapshot_path(task, snapshot_prefix(task))+"*", nil, &list); err != nil {
+		return err
+	}
+	var names []string
+	for _, snapshot := range list.Snapshots {
+		names = append(names, snapshot.Snapshot)
+	}
+	// Names end in a timestamp, so they sort oldest first.
+	sort.Strings(names)
+	for i := 0; i < len(names)-task.MaxBackup; i++ {
+		if err := snapshot_request(task, http.MethodDelete, snapshot_path(task, names[i]), nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
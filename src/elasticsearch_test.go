@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeCluster answers the snapshot API: PUT starts a snapshot, GET reports
+// state for it, or lists every stored snapshot for a name ending in *.
+type fakeCluster struct {
+	mu        sync.Mutex
+	state     string // reported for a started snapshot
+	reject    bool   // fail the PUT
+	stored    []string
+	requests  []string
+	last_body map[string]interface{}
+}
+
+func (c *fakeCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, r.Method+" "+r.URL.Path)
+	name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	switch {
+	case r.Method == http.MethodPut && c.reject:
+		http.Error(w, `{"error":"repository_missing_exception"}`, http.StatusNotFound)
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &c.last_body)
+		w.Write([]byte(`{"accepted":true}`))
+	case r.Method == http.MethodGet && strings.HasSuffix(name, "*"):
+		var list snapshotList
+		for _, stored := range c.stored {
+			list.Snapshots = append(list.Snapshots, snapshotInfo{Snapshot: stored, State: "SUCCESS"})
+		}
+		json.NewEncoder(w).Encode(list)
+	case r.Method == http.MethodGet && c.state == "":
+		w.Write([]byte(`{"snapshots":[]}`))
+	case r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(snapshotList{Snapshots: []snapshotInfo{{Snapshot: name, State: c.state}}})
+	case r.Method == http.MethodDelete:
+		w.Write([]byte(`{"acknowledged":true}`))
+	}
+}
+
+func TestBackupElasticsearch(t *testing.T) {
+	tests := []struct {
+		name   string
+		state  string
+		reject bool
+		fails  bool
+	}{
+		{"finished", "SUCCESS", false, false},
+		{"failed", "FAILED", false, true},
+		{"partial", "PARTIAL", false, true},
+		{"not found", "", false, true},
+		{"rejected", "SUCCESS", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := record_messages(t)
+			cluster := &fakeCluster{state: tt.state, reject: tt.reject}
+			server := httptest.NewServer(cluster)
+			defer server.Close()
+
+			task := BackupTask{Name: "Logs", Elasticsearch: server.URL + "/", SnapshotRepository: "backups", SnapshotIndices: "logs-*"}
+			files, err := backup_elasticsearch(task, "token", 1, true)
+			if (err != nil) != tt.fails || files != nil {
+				t.Fatalf("backup_elasticsearch = %v, %v, want failure %v", files, err, tt.fails)
+			}
+			if got := len(bot.messages()); got != map[bool]int{true: 1, false: 0}[tt.fails] {
+				t.Errorf("sent %q", bot.messages())
+			}
+			if !strings.HasPrefix(cluster.requests[0], "PUT /_snapshot/backups/logs-") {
+				t.Errorf("started %q, want a lowercase logs- snapshot in backups", cluster.requests[0])
+			}
+			if !tt.reject && (cluster.last_body["indices"] != "logs-*" || cluster.last_body["include_global_state"] != false) {
+				t.Errorf("snapshot body = %v", cluster.last_body)
+			}
+		})
+	}
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	stored := []string{"logs-20261012-100000", "logs-20261014-100000", "logs-20261010-100000", "logs-20261013-100000", "logs-20261011-100000"}
+	tests := []struct {
+		name    string
+		max     int
+		safe    bool
+		deleted []string
+	}{
+		{"keeps MaxBackup", 2, false, []string{"logs-20261010-100000", "logs-20261011-100000", "logs-20261012-100000"}},
+		{"fewer than MaxBackup", 7, false, nil},
+		{"SafeMode", 2, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := &fakeCluster{stored: stored}
+			server := httptest.NewServer(cluster)
+			defer server.Close()
+			saved := safe_mode
+			safe_mode = tt.safe
+			defer func() { safe_mode = saved }()
+
+			task := BackupTask{Name: "Logs", Elasticsearch: server.URL, SnapshotRepository: "backups", MaxBackup: tt.max}
+			if err := prune_snapshots(task); err != nil {
+				t.Fatal(err)
+			}
+			var deleted []string
+			for _, request := range cluster.requests {
+				if name := strings.TrimPrefix(request, "DELETE /_snapshot/backups/"); name != request {
+					deleted = append(deleted, name)
+				}
+			}
+			sort.Strings(deleted)
+			if strings.Join(deleted, ",") != strings.Join(tt.deleted, ",") {
+				t.Errorf("deleted %v, want %v", deleted, tt.deleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const file_list_suffix = ".filelist.txt"
+
+// fileList records every file written to an archive with its size and
+// SHA-256, so single files can be checked after a restore. A nil list
+// records nothing.
+type fileList struct {
+	lines []string
+}
+
+func new_file_list(enable bool) *fileList {
+	if !enable {
+		return nil
+	}
+	return &fileList{}
+}
+
+func (l *fileList) add(name string, size int64, sum []byte) {
+	if l == nil {
+		return
+	}
+	l.lines = append(l.lines, fmt.Sprintf("%x  %d  %s\n", sum, size, name))
+}
+
+// write stores the list next to the archive as <archive>.filelist.txt.
+func (l *fileList) write(archive string) error {
+	if l == nil {
+		return nil
+	}
+	return os.WriteFile(archive+file_list_suffix, []byte(strings.Join(l.lines, "")), 0644)
+}
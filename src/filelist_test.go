@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFileList(t *testing.T) {
+	tests := []struct {
+		name string
+		task BackupTask
+	}{
+		{"plain", BackupTask{}},
+		{"split", BackupTask{SplitBySize: 1 << 20}},
+		{"parallel", BackupTask{ArchiveWorkers: 2}},
+		{"delta", BackupTask{DeltaMode: true}},
+		{"solid", BackupTask{Solid: "gzip"}},
+	}
+	contents := map[string]string{"index.html": "<html>", "css/site.css": "body {}"}
+	var want []string
+	for name, data := range contents {
+		want = append(want, fmt.Sprintf("%x  %d  site/%s", sha256.Sum256([]byte(data)), len(data), name))
+	}
+	sort.Strings(want)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, "site")
+			store := filepath.Join(dir, "store")
+			os.MkdirAll(store, 0755)
+			for name, data := range contents {
+				os.MkdirAll(filepath.Dir(filepath.Join(source, name)), 0755)
+				os.WriteFile(filepath.Join(source, name), []byte(data), 0644)
+			}
+			task := tt.task
+			task.Website, task.BackupSource, task.StorePath, task.FileList = "site", source, store, true
+			files, err := archive_source(task, filepath.Join(store, "site-20261014-100000"+archive_extension(task)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(files[0] + file_list_suffix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+			sort.Strings(got)
+			if strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("file list:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+		})
+	}
+
+	var list *fileList
+	list.add("site/index.html", 6, nil)
+	archive := filepath.Join(t.TempDir(), "site.zip")
+	if err := list.write(archive); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(archive + file_list_suffix); err == nil {
+		t.Error("a task without FileList wrote a file list")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// FreezeGroup is a pair of commands bracketing the backups of its member
+// tasks, e.g. pausing writes or fsfreeze, so a database and the files it
+// refers to are captured at the same moment.
+type FreezeGroup struct {
+	Freeze string `json:"Freeze"`
+	Thaw   string `json:"Thaw"`
+}
+
+// frozenGroup is a FreezeGroup during a run. It is thawed once every
+// member has captured its backup.
+type frozenGroup struct {
+	name      string
+	group     FreezeGroup
+	err       error // from Freeze; the members fail without capturing
+	mu        sync.Mutex
+	remaining int
+	thawed    bool
+	telegram  Telegram
+}
+
+// run_freezes holds the groups frozen for this run, by name, so that
+// thaw_all can thaw whatever is still frozen when the run is ended early.
+var (
+	run_freezes    map[string]*frozenGroup
+	run_freezes_mu sync.Mutex
+)
+
+// validate_freeze_groups checks that every task's FreezeGroup is defined
+// and every group has both commands.
+func validate_freeze_groups(config Config) error {
+	for name, group := range config.FreezeGroups {
+		if group.Freeze == "" || group.Thaw == "" {
+			return fmt.Errorf("FreezeGroup %q needs Freeze and Thaw", name)
+		}
+	}
+	for _, task := range all_tasks(config) {
+		if _, ok := config.FreezeGroups[task.FreezeGroup]; task.FreezeGroup != "" && !ok {
+			return fmt.Errorf("task %s: unknown FreezeGroup %q", task_name(task), task.FreezeGroup)
+		}
+	}
+	return nil
+}
+
+// freeze_groups runs the Freeze command of every group with a member task,
+// once, before any task starts, and records the groups in run_freezes. A
+// group whose Freeze fails is still thawed once its members are done, in
+// case it froze part way.
+func freeze_groups(config Config) {
+	frozen := map[string]*frozenGroup{}
+	for _, task := range all_tasks(config) {
+		if task.FreezeGroup == "" {
+			continue
+		}
+		if f, ok := frozen[task.FreezeGroup]; ok {
+			f.remaining++
+			continue
+		}
+		frozen[task.FreezeGroup] = &frozenGroup{
+			name:      task.FreezeGroup,
+			group:     config.FreezeGroups[task.FreezeGroup],
+			remaining: 1,
+			telegram:  config.Telegram,
+		}
+	}
+	// Recorded before freezing: a RunTimeout exit during a stuck Freeze
+	// must still thaw.
+	run_freezes_mu.Lock()
+	run_freezes = frozen
+	run_freezes_mu.Unlock()
+	for _, f := range frozen {
+		log.Printf("Freezing %s", f.name)
+		if err := freeze_command(f.group.Freeze); err != nil {
+			f.err = fmt.Errorf("freeze %s failed: %v", f.name, err)
+			log.Printf("Error: %v", f.err)
+			send_message(f.telegram.BotToken, f.telegram.ChatID, "Freeze FAILED: "+f.name, f.telegram.Enable)
+		}
+	}
+}
+
+// join_freeze returns the func a member task calls once it has captured its
+// backup, and the group's Freeze error, if any. The last member to call it
+// runs Thaw. It is safe to call more than once.
+func join_freeze(task BackupTask) (func(), error) {
+	f := run_freezes[task.FreezeGroup]
+	if f == nil {
+		return func() {}, nil
+	}
+	var once sync.Once
+	return func() { once.Do(f.leave) }, f.err
+}
+
+func (f *frozenGroup) leave() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.remaining--
+	if f.remaining > 0 {
+		return
+	}
+	f.thaw()
+}
+
+// thaw runs Thaw unless the group has been thawed already. f.mu is held.
+func (f *frozenGroup) thaw() {
+	if f.thawed {
+		return
+	}
+	f.thawed = true
+	log.Printf("Thawing %s", f.name)
+	if err := freeze_command(f.group.Thaw); err != nil {
+		log.Printf("Error: thaw %s failed: %v", f.name, err)
+		send_message(f.telegram.BotToken, f.telegram.ChatID, "Thaw FAILED: "+f.name, f.telegram.Enable)
+	}
+}
+
+// thaw_all thaws every group still frozen. It runs before goBack exits on
+// a cut-off or RunTimeout, when tasks stuck past run_cancel_grace never
+// reach their own thaw.
+func thaw_all() {
+	run_freezes_mu.Lock()
+	defer run_freezes_mu.Unlock()
+	for _, f := range run_freezes {
+		f.mu.Lock()
+		f.thaw()
+		f.mu.Unlock()
+	}
+}
+
+// freeze_command runs a Freeze or Thaw command. It is not tied to run_ctx:
+// a thaw must still run when MaxRunDuration has cut the run off.
+func freeze_command(command string) error {
+	output, err := exec.Command("sh", "-c", command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
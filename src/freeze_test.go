@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFreezeGroups(t *testing.T) {
+	tests := []struct {
+		name     string
+		leaving  int // members that finish and call their thaw
+		thaw_all bool
+		want     string
+	}{
+		{"all members finish", 2, false, "freeze\nthaw\n"},
+		{"one member finishes", 1, false, "freeze\n"},
+		{"stuck member thawed on exit", 1, true, "freeze\nthaw\n"},
+		{"thaw on exit after all finished", 2, true, "freeze\nthaw\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() { run_freezes = nil }()
+			log := filepath.Join(t.TempDir(), "log")
+			config := Config{
+				FreezeGroups: map[string]FreezeGroup{"shop": {
+					Freeze: "echo freeze >> " + log,
+					Thaw:   "echo thaw >> " + log,
+				}},
+				DatabaseTasks: []BackupTask{{Database: "shop", FreezeGroup: "shop"}},
+				WebsiteTasks:  []BackupTask{{Website: "shop", FreezeGroup: "shop"}},
+			}
+			if err := validate_freeze_groups(config); err != nil {
+				t.Fatal(err)
+			}
+			freeze_groups(config)
+			for _, task := range all_tasks(config)[:tt.leaving] {
+				thaw, err := join_freeze(task)
+				if err != nil {
+					t.Fatal(err)
+				}
+				thaw()
+				thaw()
+			}
+			if tt.thaw_all {
+				thaw_all()
+			}
+			data, _ := os.ReadFile(log)
+			if string(data) != tt.want {
+				t.Errorf("commands ran %q, want %q", strings.Fields(string(data)), strings.Fields(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// backup_set_complete reports whether set still holds its archive: the
+// archive itself, or the first part of a split archive. Rotation removes a
+// set's files in name order, so a run that died while pruning leaves a set
+// that has lost its archive or leading parts but kept the rest. A delta
+// whose baseline is gone is incomplete as well.
+func backup_set_complete(set *backupSet, ext string) bool {
+	if strings.HasSuffix(set.key, delta_marker+ext) {
+		return false
+	}
+	for _, name := range set.files {
+		if name == set.key || name == split_part_name(set.key, ext, 1) {
+			return true
+		}
+	}
+	return false
+}
+
+// heal_backup_sets removes what an interrupted rotation left of the task's
+// backups before the run starts: orphaned sidecars and sets that can no
+// longer be restored. Left alone they would count against MaxBackup, be
+// uploaded and show up in restores.
+func heal_backup_sets(task BackupTask) {
+	if task.StorePath == "" || task.Elasticsearch != "" {
+		return
+	}
+	remove_partial_files(task)
+	ext := archive_extension(task)
+	for _, set := range backup_sets(task) {
+		if !backup_timestamp_pattern.MatchString(set.key) || backup_set_complete(set, ext) {
+			continue
+		}
+		if safe_mode {
+			log.Printf("SafeMode: not removing incomplete backup %s of %s: %s", set.key, task_name(task), strings.Join(set.files, ", "))
+			continue
+		}
+		log.Printf("Removing incomplete backup %s of %s left by an interrupted rotation: %s", set.key, task_name(task), strings.Join(set.files, ", "))
+		for _, name := range set.files {
+			if err := os.Remove(filepath.Join(task.StorePath, name)); err != nil {
+				log.Printf("Error removing %s: %v", name, err)
+			}
+		}
+	}
+}
+
+// remove_partial_files removes the archives and dumps a killed run left
+// unfinished under partial_name. Nothing writes them once the run is over.
+func remove_partial_files(task BackupTask) {
+	entries, err := os.ReadDir(task.StorePath)
+	if err != nil {
+		return
+	}
+	pattern := task_file_pattern(task)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, partial_prefix) || !pattern.MatchString(strings.TrimPrefix(name, partial_prefix)) {
+			continue
+		}
+		if safe_mode {
+			log.Printf("SafeMode: not removing unfinished backup %s of %s", name, task_name(task))
+			continue
+		}
+		log.Printf("Removing unfinished backup %s of %s left by an interrupted run", name, task_name(task))
+		if err := os.Remove(filepath.Join(task.StorePath, name)); err != nil {
+			log.Printf("Error removing %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHealBackupSets(t *testing.T) {
+	list := file_list_suffix
+	tests := []struct {
+		name  string
+		task  BackupTask
+		files []string
+		want  []string
+	}{
+		{"complete", BackupTask{},
+			[]string{"site-20261014-100000.zip", "site-20261014-100000.zip" + list},
+			[]string{"site-20261014-100000.zip", "site-20261014-100000.zip" + list}},
+		{"orphaned sidecar", BackupTask{},
+			[]string{"site-20261013-100000.zip" + list, "site-20261014-100000.zip"},
+			[]string{"site-20261014-100000.zip"}},
+		{"split missing its first part", BackupTask{},
+			[]string{"site-20261013-100000.part002.zip", "site-20261013-100000.part003.zip", "site-20261014-100000.part001.zip", "site-20261014-100000.part002.zip"},
+			[]string{"site-20261014-100000.part001.zip", "site-20261014-100000.part002.zip"}},
+		{"delta chain without its baseline", BackupTask{DeltaMode: true},
+			[]string{"site-20261012-100000.delta.zip", "site-20261013-100000.zip", "site-20261014-100000.delta.zip"},
+			[]string{"site-20261013-100000.zip", "site-20261014-100000.delta.zip"}},
+		{"unfinished archive", BackupTask{},
+			[]string{partial_prefix + "site-20261014-110000.zip", "site-20261014-100000.zip"},
+			[]string{"site-20261014-100000.zip"}},
+		{"other tasks", BackupTask{},
+			[]string{"site-staging-20261013-100000.zip" + list, "site-20261014-100000.zip"},
+			[]string{"site-20261014-100000.zip", "site-staging-20261013-100000.zip" + list}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.files {
+				os.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+			}
+			task := tt.task
+			task.Website, task.StorePath = "site", dir
+			heal_backup_sets(task)
+			if got := strings.Join(remaining(dir), ","); got != strings.Join(tt.want, ",") {
+				t.Errorf("kept %s, want %s", got, strings.Join(tt.want, ","))
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	duration_state_file     = ".goBack-durations.json"
+	duration_history        = 10
+	duration_min_history    = 3
+	default_slowdown_factor = 2.0
+)
+
+// durations_mu serialises updates of the duration state, which tasks
+// sharing a StorePath also share.
+var durations_mu sync.Mutex
+
+// load_durations returns the recent backup durations, in seconds, of each
+// task in store_path, oldest first.
+func load_durations(store_path string) map[string][]float64 {
+	state := map[string][]float64{}
+	data, err := os.ReadFile(filepath.Join(store_path, duration_state_file))
+	if err == nil {
+		json.Unmarshal(data, &state)
+	}
+	return state
+}
+
+func save_durations(store_path string, state map[string][]float64) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(store_path, duration_state_file), data, 0644)
+}
+
+// slowdown compares took with the average of history. It reports the
+// ratio and whether it exceeds factor; with too little history there is
+// no verdict.
+func slowdown(history []float64, took time.Duration, factor float64) (float64, time.Duration, bool) {
+	if len(history) < duration_min_history {
+		return 0, 0, false
+	}
+	var sum float64
+	for _, seconds := range history {
+		sum += seconds
+	}
+	average := sum / float64(len(history))
+	if average <= 0 {
+		return 0, 0, false
+	}
+	ratio := took.Seconds() / average
+	return ratio, time.Duration(average * float64(time.Second)), ratio > factor
+}
+
+// check_slowdown records how long the task took to write its backup and
+// alerts when that is more than SlowdownFactor times the average of its
+// last runs, which often means growing data or a failing disk.
+func check_slowdown(task BackupTask, took time.Duration, botToken string, chatID int64, enable bool) {
+	factor := task.SlowdownFactor
+	if factor <= 0 {
+		factor = default_slowdown_factor
+	}
+	name := task_name(task)
+
+	durations_mu.Lock()
+	defer durations_mu.Unlock()
+	state := load_durations(task.StorePath)
+	history := state[name]
+	if ratio, average, slow := slowdown(history, took, factor); slow {
+		message := fmt.Sprintf("Backup Slowdown: %s took %s, %.1fx its average of %s over the last %d runs", name, round_duration(took), ratio, round_duration(average), len(history))
+		log.Print(message)
+		send_message(botToken, chatID, message, enable)
+	}
+	history = append(history, took.Seconds())
+	if len(history) > duration_history {
+		history = history[len(history)-duration_history:]
+	}
+	state[name] = history
+	if err := save_durations(task.StorePath, state); err != nil {
+		log.Printf("Error saving backup durations for %s: %v", name, err)
+	}
+}
+
+// round_duration rounds d for a message, keeping a tenth of a second for
+// runs under a minute.
+func round_duration(d time.Duration) time.Duration {
+	if d < time.Minute {
+		return d.Round(100 * time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowdown(t *testing.T) {
+	tests := []struct {
+		history []float64
+		took    time.Duration
+		factor  float64
+		ratio   float64
+		slow    bool
+	}{
+		{nil, time.Hour, 2, 0, false},
+		{[]float64{10, 10}, time.Hour, 2, 0, false},
+		{[]float64{10, 10, 10}, 15 * time.Second, 2, 1.5, false},
+		{[]float64{10, 10, 10}, 20 * time.Second, 2, 2, false},
+		{[]float64{10, 10, 10}, 25 * time.Second, 2, 2.5, true},
+		{[]float64{8, 10, 12}, 16 * time.Second, 1.5, 1.6, true},
+		{[]float64{0, 0, 0}, time.Second, 2, 0, false},
+	}
+	for _, tt := range tests {
+		ratio, _, slow := slowdown(tt.history, tt.took, tt.factor)
+		if slow != tt.slow || fmt.Sprintf("%.2f", ratio) != fmt.Sprintf("%.2f", tt.ratio) {
+			t.Errorf("slowdown(%v, %v, %v) = %.2f, %v, want %.2f, %v", tt.history, tt.took, tt.factor, ratio, slow, tt.ratio, tt.slow)
+		}
+	}
+}
+
+func TestCheckSlowdown(t *testing.T) {
+	bot := record_messages(t)
+	dir := t.TempDir()
+	task := BackupTask{Website: "site", StorePath: dir}
+	runs := []time.Duration{10 * time.Second, 12 * time.Second, 8 * time.Second, 11 * time.Second, 35 * time.Second}
+	for _, took := range runs {
+		check_slowdown(task, took, "token", 1, true)
+	}
+	want := "Backup Slowdown: site took 35s, 3.4x its average of 10.3s over the last 4 runs"
+	if got := strings.Join(bot.messages(), ","); got != want {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+
+	// Only the last duration_history runs are kept, per task.
+	for i := 0; i < duration_history+5; i++ {
+		check_slowdown(task, 10*time.Second, "", 0, false)
+	}
+	check_slowdown(BackupTask{Database: "shop", StorePath: dir}, time.Second, "", 0, false)
+	state := load_durations(dir)
+	if len(state["site"]) != duration_history || len(state["shop"]) != 1 {
+		t.Errorf("kept %d runs of site and %d of shop", len(state["site"]), len(state["shop"]))
+	}
+}
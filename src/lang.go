@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// builtin_catalogs translate the fixed part of each notification: the text
+// before its first ": ", which names the event. Names, paths and details
+// after it are sent as they are.
+var builtin_catalogs = map[string]map[string]string{
+	"de": {
+		"Website Backup FAILED":                 "Website-Sicherung FEHLGESCHLAGEN",
+		"Database Backup FAILED":                "Datenbank-Sicherung FEHLGESCHLAGEN",
+		"Database PreDumpSQL FAILED":            "Datenbank-PreDumpSQL FEHLGESCHLAGEN",
+		"Database PostDumpSQL FAILED":           "Datenbank-PostDumpSQL FEHLGESCHLAGEN",
+		"Database Stream Upload FAILED":         "Datenbank-Stream-Upload FEHLGESCHLAGEN",
+		"Database Compression FAILED":           "Datenbank-Komprimierung FEHLGESCHLAGEN",
+		"Config Backup FAILED":                  "Konfigurations-Sicherung FEHLGESCHLAGEN",
+		"Elasticsearch Snapshot FAILED":         "Elasticsearch-Snapshot FEHLGESCHLAGEN",
+		"Device Backup FAILED":                  "Geräte-Sicherung FEHLGESCHLAGEN",
+		"Backup FAILED":                         "Sicherung FEHLGESCHLAGEN",
+		"Backup Verify FAILED":                  "Prüfung der Sicherung FEHLGESCHLAGEN",
+		"Mirror Backup FAILED":                  "Spiegel-Sicherung FEHLGESCHLAGEN",
+		"Copy to onedrive FAILED":               "Upload FEHLGESCHLAGEN",
+		"Verify onedrive upload FAILED":         "Prüfung des Uploads FEHLGESCHLAGEN",
+		"Verify remote backups FAILED":          "Prüfung der entfernten Sicherungen FEHLGESCHLAGEN",
+		"Restore Check FAILED":                  "Wiederherstellungsprüfung FEHLGESCHLAGEN",
+		"Freeze FAILED":                         "Einfrieren FEHLGESCHLAGEN",
+		"Thaw FAILED":                           "Auftauen FEHLGESCHLAGEN",
+		"Clock Check FAILED":                    "Uhrzeitprüfung FEHLGESCHLAGEN",
+		"Backup Slowdown":                       "Sicherung verlangsamt",
+		"Backup Summary":                        "Sicherungsübersicht",
+		"Remote Quota":                          "Speicherplatz am Ziel",
+		"rclone remote needs re-authentication": "rclone-Remote muss neu angemeldet werden",
+	},
+	"zh": {
+		"Website Backup FAILED":                 "网站备份失败",
+		"Database Backup FAILED":                "数据库备份失败",
+		"Database PreDumpSQL FAILED":            "数据库 PreDumpSQL 执行失败",
+		"Database PostDumpSQL FAILED":           "数据库 PostDumpSQL 执行失败",
+		"Database Stream Upload FAILED":         "数据库流式上传失败",
+		"Database Compression FAILED":           "数据库压缩失败",
+		"Config Backup FAILED":                  "配置备份失败",
+		"Elasticsearch Snapshot FAILED":         "Elasticsearch 快照失败",
+		"Device Backup FAILED":                  "设备备份失败",
+		"Backup FAILED":                         "备份失败",
+		"Backup Verify FAILED":                  "备份校验失败",
+		"Mirror Backup FAILED":                  "镜像备份失败",
+		"Copy to onedrive FAILED":               "上传失败",
+		"Verify onedrive upload FAILED":         "上传校验失败",
+		"Verify remote backups FAILED":          "远程备份校验失败",
+		"Restore Check FAILED":                  "恢复检查失败",
+		"Freeze FAILED":                         "冻结失败",
+		"Thaw FAILED":                           "解冻失败",
+		"Clock Check FAILED":                    "时钟检查失败",
+		"Backup Slowdown":                       "备份变慢",
+		"Backup Summary":                        "备份摘要",
+		"Remote Quota":                          "远程存储配额",
+		"rclone remote needs re-authentication": "rclone 远程需要重新认证",
+	},
+}
+
+// message_catalog is the catalog for the configured Language, nil for
+// English.
+var message_catalog map[string]string
+
+// load_catalog builds the catalog for Language, with the entries of
+// LanguageFile, if set, over the built-in ones. A relative LanguageFile is
+// taken from the directory of the config at config_path.
+func load_catalog(config_path string, config Config) (map[string]string, error) {
+	english := config.Language == "" || config.Language == "en"
+	builtin, ok := builtin_catalogs[config.Language]
+	if config.LanguageFile == "" {
+		if english {
+			return nil, nil
+		} else if !ok {
+			return nil, fmt.Errorf("no built-in messages for Language %q, set LanguageFile", config.Language)
+		}
+	}
+	catalog := map[string]string{}
+	for key, value := range builtin {
+		catalog[key] = value
+	}
+	if config.LanguageFile != "" {
+		path := config.LanguageFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(config_path), path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var custom map[string]string
+		if err := json.Unmarshal(data, &custom); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for key, value := range custom {
+			catalog[key] = value
+		}
+	}
+	return catalog, nil
+}
+
+// translate_message replaces the event part of message, the text before
+// its first ": ", when the catalog has it. A message not in the catalog is
+// sent in English.
+func translate_message(catalog map[string]string, message string) string {
+	event, rest, found := strings.Cut(message, ": ")
+	translated, ok := catalog[event]
+	if !ok {
+		return message
+	}
+	if !found {
+		return translated
+	}
+	return translated + ": " + rest
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCatalog(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "fr.json"), []byte(`{"Backup FAILED": "Sauvegarde ÉCHOUÉE"}`), 0644)
+	os.WriteFile(filepath.Join(dir, "de.json"), []byte(`{"Backup FAILED": "Backup kaputt"}`), 0644)
+	os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"Backup FAILED": `), 0644)
+	tests := []struct {
+		name     string
+		config   Config
+		event    string
+		want     string // the event's translation, "" for none
+		fails    bool
+		no_table bool
+	}{
+		{"English", Config{}, "Backup FAILED", "", false, true},
+		{"en", Config{Language: "en"}, "Backup FAILED", "", false, true},
+		{"built-in", Config{Language: "de"}, "Backup FAILED", "Sicherung FEHLGESCHLAGEN", false, false},
+		{"unknown language", Config{Language: "fr"}, "", "", true, false},
+		{"LanguageFile", Config{Language: "fr", LanguageFile: "fr.json"}, "Backup FAILED", "Sauvegarde ÉCHOUÉE", false, false},
+		{"LanguageFile over built-in", Config{Language: "de", LanguageFile: "de.json"}, "Backup FAILED", "Backup kaputt", false, false},
+		{"built-in kept beside LanguageFile", Config{Language: "de", LanguageFile: "de.json"}, "Thaw FAILED", "Auftauen FEHLGESCHLAGEN", false, false},
+		{"absolute LanguageFile", Config{LanguageFile: filepath.Join(dir, "fr.json")}, "Backup FAILED", "Sauvegarde ÉCHOUÉE", false, false},
+		{"missing LanguageFile", Config{LanguageFile: "missing.json"}, "", "", true, false},
+		{"broken LanguageFile", Config{LanguageFile: "broken.json"}, "", "", true, false},
+	}
+	for _, tt := range tests {
+		catalog, err := load_catalog(filepath.Join(dir, "config.json"), tt.config)
+		if (err != nil) != tt.fails {
+			t.Errorf("%s: load_catalog = %v, want failure %v", tt.name, err, tt.fails)
+			continue
+		}
+		if (catalog == nil) != (tt.no_table || tt.fails) {
+			t.Errorf("%s: catalog = %v", tt.name, catalog)
+		}
+		if got := catalog[tt.event]; !tt.fails && got != tt.want {
+			t.Errorf("%s: %s translates to %q, want %q", tt.name, tt.event, got, tt.want)
+		}
+	}
+}
+
+func TestTranslateMessage(t *testing.T) {
+	catalog := builtin_catalogs["de"]
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"Database Backup FAILED: shop", "Datenbank-Sicherung FEHLGESCHLAGEN: shop"},
+		{"Backup FAILED: disk full on /srv/backup: 0 B free", "Sicherung FEHLGESCHLAGEN: disk full on /srv/backup: 0 B free"},
+		{"Backup Summary", "Sicherungsübersicht"},
+		{"Something new happened: site", "Something new happened: site"},
+	}
+	for _, tt := range tests {
+		if got := translate_message(catalog, tt.message); got != tt.want {
+			t.Errorf("translate_message(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+	if got := translate_message(nil, "Backup FAILED: site"); got != "Backup FAILED: site" {
+		t.Errorf("English catalog translated to %q", got)
+	}
+
+	// Every built-in language covers the same events.
+	for language, catalog := range builtin_catalogs {
+		for event := range builtin_catalogs["de"] {
+			if catalog[event] == "" {
+				t.Errorf("%s has no translation of %q", language, event)
+			}
+		}
+		if len(catalog) != len(builtin_catalogs["de"]) {
+			t.Errorf("%s has %d events, de has %d", language, len(catalog), len(builtin_catalogs["de"]))
+		}
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"archive/zip"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -10,37 +13,255 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
 type Telegram struct {
-	BotToken string `json:"BotToken"`
-	ChatID   int64  `json:"ChatID"`
-	Enable   bool   `json:"enable"`
+	BotToken         string `json:"BotToken"`
+	ChatID           int64  `json:"ChatID"`
+	Enable           bool   `json:"enable"`
+	QueueFile        string `json:"QueueFile,omitempty"`
+	QueueMaxMessages int    `json:"QueueMaxMessages,omitempty"`
+	QueueMaxAge      string `json:"QueueMaxAge,omitempty"`
 }
 
 type Config struct {
-	Telegram      Telegram     `json:"telegram"`
-	WebsiteTasks  []BackupTask `json:"WebsiteTasks"`
-	DatabaseTasks []BackupTask `json:"DatabaseTasks"`
-	ConfigTasks   []BackupTask `json:"ConfigTasks"`
+	Telegram           Telegram     `json:"telegram"`
+	WebsiteTasks       []BackupTask `json:"WebsiteTasks"`
+	DatabaseTasks      []BackupTask `json:"DatabaseTasks"`
+	ConfigTasks        []BackupTask `json:"ConfigTasks"`
+	ElasticsearchTasks []BackupTask `json:"ElasticsearchTasks,omitempty"`
+	Defaults           *BackupTask  `json:"Defaults,omitempty"`
+
+	SuccessExitCode int  `json:"SuccessExitCode,omitempty"`
+	FailureExitCode *int `json:"FailureExitCode,omitempty"`
+	PartialExitCode *int `json:"PartialExitCode,omitempty"`
+
+	PauseFile      string `json:"PauseFile,omitempty"`
+	SkipOnBattery  bool   `json:"SkipOnBattery,omitempty"`
+	MaxRunDuration string `json:"MaxRunDuration,omitempty"`
+	RunTimeout     string `json:"RunTimeout,omitempty"`
+	DedupIdentical bool   `json:"DedupIdentical,omitempty"`
+	SecretsFile    string `json:"SecretsFile,omitempty"`
+	Language       string `json:"Language,omitempty"`
+	LanguageFile   string `json:"LanguageFile,omitempty"`
+
+	IncludeConfigSnapshot bool `json:"IncludeConfigSnapshot,omitempty"`
+
+	CheckClock *ClockCheck `json:"CheckClock,omitempty"`
+
+	FreezeGroups map[string]FreezeGroup `json:"FreezeGroups,omitempty"`
+
+	SafeMode         bool `json:"SafeMode,omitempty"`
+	IncludeDiskUsage bool `json:"IncludeDiskUsage,omitempty"`
 }
 
 type BackupTask struct {
-	Name         string `json:"Name,omitempty"`
-	Website      string `json:"Website,omitempty"`
-	Database     string `json:"Database,omitempty"`
-	BackupSource string `json:"BackupSource"`
-	StorePath    string `json:"StorePath"`
-	MaxBackup    int    `json:"MaxBackup"`
-	OnedrivePath string `json:"OnedrivePath"`
+	Name                   string        `json:"Name,omitempty"`
+	Website                string        `json:"Website,omitempty"`
+	Database               string        `json:"Database,omitempty"`
+	BackupSource           string        `json:"BackupSource"`
+	StorePath              string        `json:"StorePath"`
+	MaxBackup              int           `json:"MaxBackup"`
+	RemotePath             string        `json:"RemotePath,omitempty"`
+	OnedrivePath           string        `json:"OnedrivePath,omitempty"` // deprecated alias of RemotePath
+	SplitBySize            int64         `json:"SplitBySize,omitempty"`
+	VerifyUpload           bool          `json:"VerifyUpload,omitempty"`
+	SourceListFile         string        `json:"SourceListFile,omitempty"`
+	BestCompression        bool          `json:"BestCompression,omitempty"`
+	DevicePath             string        `json:"DevicePath,omitempty"`
+	DeviceBlockSize        int           `json:"DeviceBlockSize,omitempty"`
+	PruneGracePeriod       string        `json:"PruneGracePeriod,omitempty"`
+	FileList               bool          `json:"FileList,omitempty"`
+	SchemaOnly             bool          `json:"SchemaOnly,omitempty"`
+	StreamUpload           bool          `json:"StreamUpload,omitempty"`
+	StreamFallback         bool          `json:"StreamFallback,omitempty"`
+	RestoreScript          bool          `json:"RestoreScript,omitempty"`
+	Timezone               string        `json:"Timezone,omitempty"`
+	DeltaMode              bool          `json:"DeltaMode,omitempty"`
+	DeltaFullEvery         int           `json:"DeltaFullEvery,omitempty"`
+	NotifySavings          bool          `json:"NotifySavings,omitempty"`
+	FilterCmd              string        `json:"FilterCmd,omitempty"`
+	Extension              string        `json:"Extension,omitempty"`
+	ProgressNotifyAfter    string        `json:"ProgressNotifyAfter,omitempty"`
+	VerifyRemoteCount      bool          `json:"VerifyRemoteCount,omitempty"`
+	ArchiveWorkers         int           `json:"ArchiveWorkers,omitempty"`
+	UploadProgressInterval string        `json:"UploadProgressInterval,omitempty"`
+	SortBy                 string        `json:"SortBy,omitempty"`
+	SkipUnchanged          bool          `json:"SkipUnchanged,omitempty"`
+	PostRestoreCheck       string        `json:"PostRestoreCheck,omitempty"`
+	DriftBaseline          string        `json:"DriftBaseline,omitempty"`
+	GFS                    *GFSRetention `json:"GFS,omitempty"`
+	AutoVerify             bool          `json:"AutoVerify,omitempty"`
+	ThrottleBytesPerSec    int64         `json:"ThrottleBytesPerSec,omitempty"`
+	StorePaths             []string      `json:"StorePaths,omitempty"`
+	PreDumpSQL             string        `json:"PreDumpSQL,omitempty"`
+	PostDumpSQL            string        `json:"PostDumpSQL,omitempty"`
+	MysqldumpPath          string        `json:"MysqldumpPath,omitempty"`
+	MysqlPath              string        `json:"MysqlPath,omitempty"`
+	RclonePath             string        `json:"RclonePath,omitempty"`
+	RemoteRetentionTag     string        `json:"RemoteRetentionTag,omitempty"`
+	Elasticsearch          string        `json:"Elasticsearch,omitempty"`
+	SnapshotRepository     string        `json:"SnapshotRepository,omitempty"`
+	SnapshotIndices        string        `json:"SnapshotIndices,omitempty"`
+	Solid                  string        `json:"Solid,omitempty"`
+	SolidLevel             *int          `json:"SolidLevel,omitempty"`
+	OmitEmptyDirs          bool          `json:"OmitEmptyDirs,omitempty"`
+	ZstdDictPath           string        `json:"ZstdDictPath,omitempty"`
+	SkipOpenFiles          bool          `json:"SkipOpenFiles,omitempty"`
+	FreezeGroup            string        `json:"FreezeGroup,omitempty"`
+	ParallelTables         int           `json:"ParallelTables,omitempty"`
+	AlertOnSlowdown        bool          `json:"AlertOnSlowdown,omitempty"`
+	SlowdownFactor         float64       `json:"SlowdownFactor,omitempty"`
+	Checkpoint             bool          `json:"Checkpoint,omitempty"`
+	EmbedRunLog            bool          `json:"EmbedRunLog,omitempty"`
+	RemoteQuotaAlert       bool          `json:"RemoteQuotaAlert,omitempty"`
+	RemoteQuotaPercent     float64       `json:"RemoteQuotaPercent,omitempty"`
+	GzipBlockSize          int           `json:"GzipBlockSize,omitempty"`
+	GzipWorkers            int           `json:"GzipWorkers,omitempty"`
+
+	drift  *driftReport // set by backup_config for finish_zip
+	runlog *runLog      // set by handle_task with EmbedRunLog
+}
+
+// task_name returns the name a task is reported under.
+func task_name(task BackupTask) string {
+	switch {
+	case task.Website != "":
+		return task.Website
+	case task.Database != "":
+		return task.Database
+	}
+	return task.Name
+}
+
+// normalize_config resolves deprecated options into their replacements.
+func normalize_config(config *Config) {
+	for _, tasks := range [][]BackupTask{config.WebsiteTasks, config.DatabaseTasks, config.ConfigTasks, config.ElasticsearchTasks} {
+		for i := range tasks {
+			if tasks[i].RemotePath == "" {
+				tasks[i].RemotePath = tasks[i].OnedrivePath
+			}
+		}
+	}
+}
+
+func all_tasks(config Config) []BackupTask {
+	var tasks []BackupTask
+	tasks = append(tasks, config.WebsiteTasks...)
+	tasks = append(tasks, config.DatabaseTasks...)
+	tasks = append(tasks, config.ConfigTasks...)
+	return append(tasks, config.ElasticsearchTasks...)
 }
 
-func createZip(source, target string) error {
+// validate_task checks the task options that cannot be checked by
+// unmarshalling alone.
+func validate_task(task BackupTask) error {
+	if _, err := time.LoadLocation(task.Timezone); err != nil {
+		return fmt.Errorf("invalid Timezone %q: %v", task.Timezone, err)
+	}
+	if task.DeltaMode && (task.SplitBySize > 0 || task.SourceListFile != "") {
+		return fmt.Errorf("DeltaMode cannot be combined with SplitBySize or SourceListFile")
+	}
+	if task.RemoteRetentionTag != "" && !strings.Contains(task.RemoteRetentionTag, "=") {
+		return fmt.Errorf("invalid RemoteRetentionTag %q: want key=value", task.RemoteRetentionTag)
+	}
+	if task.ZstdDictPath != "" {
+		if task.Solid != "zstd" {
+			return fmt.Errorf("ZstdDictPath needs Solid \"zstd\"")
+		}
+		if _, err := os.Stat(task.ZstdDictPath); err != nil {
+			return fmt.Errorf("invalid ZstdDictPath: %v", err)
+		}
+	}
+	if task.Solid != "" {
+		if _, ok := solid_compressor(task.Solid); !ok {
+			return fmt.Errorf("invalid Solid %q: want gzip, zstd or xz", task.Solid)
+		}
+		if task.DeltaMode || task.SplitBySize > 0 || task.SourceListFile != "" || task.ArchiveWorkers > 1 || task.Extension != "" || task.RestoreScript {
+			return fmt.Errorf("Solid cannot be combined with DeltaMode, SplitBySize, SourceListFile, ArchiveWorkers, Extension or RestoreScript")
+		}
+		if task.Solid != "gzip" {
+			// zstd and xz are run as the commands of the same name.
+			if _, err := exec.LookPath(task.Solid); err != nil {
+				return fmt.Errorf("Solid %q needs the %s command, which was not found: install it or use Solid \"gzip\"", task.Solid, task.Solid)
+			}
+		}
+	}
+	if task.SolidLevel != nil {
+		levels, ok := solid_levels[task.Solid]
+		if !ok {
+			return fmt.Errorf("SolidLevel needs Solid")
+		}
+		if *task.SolidLevel < levels[0] || *task.SolidLevel > levels[1] {
+			return fmt.Errorf("invalid SolidLevel %d: want %d to %d for Solid %q", *task.SolidLevel, levels[0], levels[1], task.Solid)
+		}
+	}
+	if task.Checkpoint && (task.SplitBySize <= 0 || task.RestoreScript) {
+		return fmt.Errorf("Checkpoint needs SplitBySize and cannot be combined with RestoreScript")
+	}
+	if task.SlowdownFactor != 0 && task.SlowdownFactor <= 1 {
+		return fmt.Errorf("invalid SlowdownFactor %v: must be above 1", task.SlowdownFactor)
+	}
+	if task.GzipBlockSize != 0 && task.GzipBlockSize <= 16384 {
+		return fmt.Errorf("invalid GzipBlockSize %d: must be over 16384 bytes", task.GzipBlockSize)
+	}
+	if task.GzipWorkers < 0 {
+		return fmt.Errorf("invalid GzipWorkers %d: must not be negative", task.GzipWorkers)
+	}
+	if task.RemoteQuotaPercent < 0 || task.RemoteQuotaPercent > 100 {
+		return fmt.Errorf("invalid RemoteQuotaPercent %v: want 0 to 100", task.RemoteQuotaPercent)
+	}
+	if task.ParallelTables > 1 && (task.StreamUpload || task.ThrottleBytesPerSec > 0) {
+		return fmt.Errorf("ParallelTables cannot be combined with StreamUpload or ThrottleBytesPerSec")
+	}
+	if task.Extension != "" && (!strings.HasPrefix(task.Extension, ".") || strings.ContainsAny(task.Extension, "/ ")) {
+		return fmt.Errorf("invalid Extension %q: must start with a dot", task.Extension)
+	}
+	if task.PruneGracePeriod != "" {
+		if _, err := time.ParseDuration(task.PruneGracePeriod); err != nil {
+			return fmt.Errorf("invalid PruneGracePeriod %q: %v", task.PruneGracePeriod, err)
+		}
+	}
+	if task.GFS != nil {
+		if err := validate_gfs(task.GFS); err != nil {
+			return err
+		}
+	}
+	if err := valid_sort_by(task.SortBy); err != nil {
+		return err
+	}
+	if task.UploadProgressInterval != "" {
+		if _, err := time.ParseDuration(task.UploadProgressInterval); err != nil {
+			return fmt.Errorf("invalid UploadProgressInterval %q: %v", task.UploadProgressInterval, err)
+		}
+	}
+	if task.ProgressNotifyAfter != "" {
+		if _, err := time.ParseDuration(task.ProgressNotifyAfter); err != nil {
+			return fmt.Errorf("invalid ProgressNotifyAfter %q: %v", task.ProgressNotifyAfter, err)
+		}
+	}
+	return nil
+}
+
+// backup_timestamp formats t for backup file names in the task's Timezone,
+// which defaults to UTC so names agree across hosts.
+func backup_timestamp(task BackupTask, t time.Time) string {
+	location, err := time.LoadLocation(task.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	return t.In(location).Format("20060102-150405")
+}
+
+func createZip(task BackupTask, target string) error {
 	zipfile, err := os.Create(target)
 	if err != nil {
 		return err
@@ -50,117 +271,695 @@ func createZip(source, target string) error {
 	archive := zip.NewWriter(zipfile)
 	defer archive.Close()
 
-	err = filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
+	entries, err := source_entries(task, filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+
+	list := new_file_list(task.FileList)
+	bar := new_progress_bar("Archiving "+task_name(task), int64(len(entries)))
+	defer bar.finish()
+	for _, entry := range entries {
+		if err := add_zip_entry_named(archive, entry.name, entry.path, entry.info, list); err != nil {
 			return err
 		}
+		bar.add(1)
+	}
+	return finish_zip(archive, task, target, list)
+}
 
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
+// createFileZip archives a BackupSource that is a single file as one entry
+// named after the file. Splitting and deltas do not apply to it. With
+// SkipOpenFiles, a file open for writing fails the backup, as leaving it
+// out would leave nothing to back up.
+func createFileZip(task BackupTask, target string, info os.FileInfo) error {
+	if task.SkipOpenFiles && is_open(files_open_for_writing(), task.BackupSource) {
+		return fmt.Errorf("%s is open for writing by another process", task.BackupSource)
+	}
+	zipfile, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer zipfile.Close()
+
+	archive := zip.NewWriter(zipfile)
+	defer archive.Close()
+
+	list := new_file_list(task.FileList)
+	if err := add_zip_entry_named(archive, filepath.Base(task.BackupSource), task.BackupSource, info, list); err != nil {
+		return err
+	}
+	return finish_zip(archive, task, target, list)
+}
+
+// finish_zip adds the per-task extras to a fully walked archive and closes
+// it, so a failure writing the central directory is not lost.
+func finish_zip(archive *zip.Writer, task BackupTask, target string, list *fileList) error {
+	if task.drift != nil {
+		if err := add_drift_report(archive, task.drift); err != nil {
+			return err
+		}
+	}
+	if task.RestoreScript {
+		if err := add_restore_script(archive, task, target); err != nil {
 			return err
 		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return list.write(target)
+}
+
+// path_within reports whether path is dir or lies below it. The backup's own
+// StorePath is never archived, even when it sits inside BackupSource.
+func path_within(path, dir string) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
 
-		header.Name = filepath.Join(filepath.Base(source), path[len(source):])
+func add_zip_entry(archive *zip.Writer, source, path string, info os.FileInfo, list *fileList) error {
+	return add_zip_entry_named(archive, filepath.Join(filepath.Base(source), path[len(source):]), path, info, list)
+}
 
-		if info.IsDir() {
-			header.Name += "/"
-		} else {
-			header.Method = zip.Deflate
-		}
+func add_zip_entry_named(archive *zip.Writer, name, path string, info os.FileInfo, list *fileList) error {
+	if err := run_ctx.Err(); err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+
+	header.Name = name
 
-		writer, err := archive.CreateHeader(header)
+	if info.IsDir() {
+		header.Name += "/"
+	} else {
+		header.Method = zip.Deflate
+	}
+
+	writer, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		file, err := os.Open(path)
 		if err != nil {
 			return err
 		}
+		defer file.Close()
+		if list == nil {
+			_, err = io.Copy(writer, contextReader{file})
+			return err
+		}
+		hash := sha256.New()
+		size, err := io.Copy(io.MultiWriter(writer, hash), contextReader{file})
+		if err != nil {
+			return err
+		}
+		list.add(name, size, hash.Sum(nil))
+	}
+	return nil
+}
 
-		if !info.IsDir() {
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-			_, err = io.Copy(writer, file)
-			if err != nil {
-				return err
-			}
+// archive_source writes the task's archive and returns the files created.
+// The archive is written under partial_name and only renamed once it is
+// complete, so an interrupted run never leaves a file that looks like a
+// backup.
+func archive_source(task BackupTask, zip_file string) ([]string, error) {
+	files, err := write_archive(task, partial_name(zip_file))
+	if err != nil {
+		remove_backup_files(files)
+		return nil, err
+	}
+	committed, err := commit_partial_files(files)
+	if err != nil {
+		remove_backup_files(files)
+	}
+	return committed, err
+}
 
+func write_archive(task BackupTask, zip_file string) ([]string, error) {
+	if task.SourceListFile == "" && task.Solid == "" {
+		if info, err := os.Stat(task.BackupSource); err == nil && !info.IsDir() {
+			return []string{zip_file}, createFileZip(task, zip_file, info)
 		}
-		return err
-	})
+	}
+	if task.DeltaMode {
+		written, err := createDeltaZip(task, zip_file)
+		return []string{written}, err
+	}
+	if task.SourceListFile != "" {
+		return []string{zip_file}, createZipFromList(task, zip_file)
+	}
+	if task.SplitBySize > 0 {
+		return createSplitZip(task, zip_file)
+	}
+	if task.Solid != "" {
+		return []string{zip_file}, createSolidArchive(task, zip_file)
+	}
+	if task.ArchiveWorkers > 1 {
+		return []string{zip_file}, createParallelZip(task, zip_file)
+	}
+	return []string{zip_file}, createZip(task, zip_file)
+}
 
-	return err
+// telegramBot is the part of tgbotapi.BotAPI goBack uses.
+type telegramBot interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+}
+
+// new_telegram_bot connects to Telegram with botToken. Tests replace it to
+// see the messages sent.
+var new_telegram_bot = func(botToken string) (telegramBot, error) {
+	return tgbotapi.NewBotAPI(botToken)
 }
 
 func send_message(botToken string, chatID int64, message string, enable bool) {
 	if !enable {
 		return
 	}
-	bot, err := tgbotapi.NewBotAPI(botToken)
+	message = translate_message(message_catalog, message)
+	bot, err := new_telegram_bot(botToken)
 	if err != nil {
-		log.Fatalf("Error creating Telegram bot: %v", err)
+		if telegram_queue == nil {
+			log.Fatalf("Error creating Telegram bot: %v", err)
+		}
+		log.Printf("Error creating Telegram bot: %v", err)
+		telegram_queue.add(chatID, message)
+		return
 	}
 	msg := tgbotapi.NewMessage(chatID, message)
 	_, err = bot.Send(msg)
 	if err != nil {
 		log.Printf("Error sending message: %v", err)
+		telegram_queue.add(chatID, message)
 	}
 }
 
-func backup_website(task BackupTask, botToken string, chatID int64, enable bool) {
-	zip_file := task.StorePath + "/" + task.Website + "-" + time.Now().Format("20060102-150405") + ".zip"
-	err := createZip(task.BackupSource, zip_file)
-	if err != nil {
+func backup_website(task BackupTask, botToken string, chatID int64, enable bool) ([]string, error) {
+	zip_file := task.StorePath + "/" + task.Website + "-" + backup_timestamp(task, time.Now()) + archive_extension(task)
+	start := time.Now()
+	files, err := archive_source(task, zip_file)
+	task.runlog.archived(task, files, start, err)
+	if is_disk_full(err, "") {
+		report_disk_full(task, files, botToken, chatID, enable)
+	} else if err != nil {
 		send_message(botToken, chatID, "Website Backup FAILED: "+task.Website, enable)
+	} else if task.DeltaMode && task.NotifySavings {
+		notify_delta_savings(task, task.Website, botToken, chatID, enable)
 	}
+	return files, err
 }
 
-func backup_database(task BackupTask, botToken string, chatID int64, enable bool) {
-	backup_file := task.Database + "-" + time.Now().Format("20060102-150405") + ".sql"
-	mysqldump_command := "mysqldump " + task.Database + " > " + task.StorePath + "/" + backup_file
-	if _, err := exec.Command("sh", "-c", mysqldump_command).Output(); err != nil {
+func backup_database(task BackupTask, botToken string, chatID int64, enable bool) ([]string, error) {
+	backup_file := task.Database + "-" + backup_timestamp(task, time.Now())
+	mysqldump_command := shell_quote(binary(task.MysqldumpPath, "mysqldump")) + " " + task.Database
+	if task.SchemaOnly {
+		backup_file += "-schema"
+		mysqldump_command += " --no-data"
+	}
+	if task.ThrottleBytesPerSec > 0 {
+		// A throttled dump runs longer; take a consistent snapshot rather
+		// than holding table locks for the whole dump.
+		mysqldump_command += " --single-transaction"
+	}
+	backup_file += archive_extension(task)
+	var position string
+	if task.SkipUnchanged {
+		// Read the position before dumping, so writes made during the dump
+		// are picked up by the next run.
+		var err error
+		position, err = binlog_position(task)
+		if err != nil {
+			log.Printf("Error reading binlog position for %s, dumping anyway: %v", task.Database, err)
+		} else if load_binlog_state(task.StorePath)[task.Database] == position {
+			return nil, errUnchanged
+		}
+	}
+	if task.PreDumpSQL != "" {
+		if err := run_sql(task, task.PreDumpSQL); err != nil {
+			log.Printf("Error running PreDumpSQL for %s: %v", task.Database, err)
+			send_message(botToken, chatID, "Database PreDumpSQL FAILED: "+task.Database, enable)
+			return nil, err
+		}
+	}
+	if task.PostDumpSQL != "" {
+		defer func() {
+			if err := run_sql(task, task.PostDumpSQL); err != nil {
+				log.Printf("Error running PostDumpSQL for %s: %v", task.Database, err)
+				send_message(botToken, chatID, "Database PostDumpSQL FAILED: "+task.Database, enable)
+			}
+		}()
+	}
+	if task.StreamUpload {
+		err := stream_to_rclone(task, mysqldump_command, task.RemotePath+"/"+backup_file)
+		if err == nil {
+			record_binlog_position(task, position)
+			return nil, nil
+		}
+		log.Printf("Streaming %s to %s failed: %v", task.Database, task.RemotePath, err)
+		if !task.StreamFallback {
+			send_message(botToken, chatID, "Database Stream Upload FAILED: "+task.Database, enable)
+			return nil, err
+		}
+		// rcat cannot resume, so retry the whole dump through local disk.
+	}
+	// Like archives, the dump gets its final name only once it is complete.
+	dump_file := partial_name(task.StorePath + "/" + backup_file)
+	dump := dump_to_file
+	if task.ParallelTables > 1 {
+		dump = dump_tables
+	}
+	if err := dump(task, mysqldump_command, dump_file); err != nil {
+		if is_disk_full(err, err.Error()) {
+			report_disk_full(task, []string{dump_file}, botToken, chatID, enable)
+			return nil, err
+		}
+		remove_backup_files([]string{dump_file})
 		send_message(botToken, chatID, "Database Backup FAILED: "+task.Database, enable)
+		return nil, err
 	}
+	if task.BestCompression {
+		compressed, err := compress_best(task, dump_file)
+		if is_disk_full(err, "") {
+			report_disk_full(task, []string{dump_file}, botToken, chatID, enable)
+			return nil, err
+		} else if err != nil {
+			remove_backup_files([]string{dump_file})
+			send_message(botToken, chatID, "Database Compression FAILED: "+task.Database, enable)
+			return nil, err
+		}
+		task.runlog.printf("Compressed to %s", filepath.Base(final_name(compressed)))
+		dump_file = compressed
+	}
+	committed, err := commit_partial_files([]string{dump_file})
+	if err != nil {
+		remove_backup_files([]string{dump_file})
+		send_message(botToken, chatID, "Database Backup FAILED: "+task.Database, enable)
+		return nil, err
+	}
+	dump_file = committed[0]
+	record_binlog_position(task, position)
+	if task.RestoreScript {
+		script := database_restore_script(task, filepath.Base(dump_file))
+		if err := os.WriteFile(dump_file+restore_script_suffix, []byte(script), 0755); err != nil {
+			log.Printf("Error writing restore script for %s: %v", task.Database, err)
+		}
+	}
+	return []string{dump_file}, nil
 }
 
-func backup_config(task BackupTask, botToken string, chatID int64, enable bool) {
-	zip_file := task.StorePath + "/" + task.Name + "-" + time.Now().Format("20060102-150405") + ".zip"
-	err := createZip(task.BackupSource, zip_file)
-	if err != nil {
+func backup_config(task BackupTask, botToken string, chatID int64, enable bool) ([]string, error) {
+	if task.DriftBaseline != "" {
+		report, err := config_drift(task)
+		if err != nil {
+			log.Printf("Error comparing %s with %s: %v", task.Name, task.DriftBaseline, err)
+		} else {
+			task.drift = report
+		}
+	}
+	zip_file := task.StorePath + "/" + task.Name + "-" + backup_timestamp(task, time.Now()) + archive_extension(task)
+	start := time.Now()
+	files, err := archive_source(task, zip_file)
+	task.runlog.archived(task, files, start, err)
+	if is_disk_full(err, "") {
+		report_disk_full(task, files, botToken, chatID, enable)
+	} else if err != nil {
 		send_message(botToken, chatID, "Config Backup FAILED: "+task.Name, enable)
+	} else if task.DeltaMode && task.NotifySavings {
+		notify_delta_savings(task, task.Name, botToken, chatID, enable)
+	}
+	if err == nil && task.drift != nil && !task.drift.empty() {
+		send_message(botToken, chatID, "Config drift on "+task.Name+": "+task.drift.summary(drift_notify_lines), enable)
 	}
+	return files, err
 }
 
-func check_backup_file_num(task BackupTask) {
+// backupSet groups the files written by one run, e.g. the parts of a split
+// archive, so rotation keeps or removes them together.
+type backupSet struct {
+	key     string
+	files   []string
+	modTime time.Time
+}
+
+// archive_extension is the extension of the task's backup files: the
+// task's Extension override, or .zip for archives and .sql for dumps. It
+// only changes the name, never the format.
+func archive_extension(task BackupTask) string {
+	switch {
+	case task.Extension != "":
+		return task.Extension
+	case task.Database != "":
+		return ".sql"
+	case task.Solid != "":
+		return solid_extension(task)
+	}
+	return ".zip"
+}
+
+// backup_sidecar_suffixes are appended to an archive's name by files that
+// describe it and must be rotated along with it.
+var backup_sidecar_suffixes = []string{file_list_suffix, restore_script_suffix, zstd_dict_suffix, config_snapshot_suffix, run_log_suffix}
+
+// task_file_pattern matches the names of the task's backup files and
+// their sidecars: the task's name, a timestamp, then only the markers and
+// extensions goBack writes. A bare name prefix would also match another
+// task's files, e.g. "site-staging-..." for task "site".
+func task_file_pattern(task BackupTask) *regexp.Regexp {
+	var compressions, sidecars []string
+	for _, c := range best_compressors {
+		compressions = append(compressions, regexp.QuoteMeta(c.ext))
+	}
+	for _, suffix := range backup_sidecar_suffixes {
+		sidecars = append(sidecars, regexp.QuoteMeta(suffix))
+	}
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(task_name(task)) + `-\d{8}-\d{6}(-schema)?(` + regexp.QuoteMeta(delta_marker) + `)?(\.part\d{3})?` +
+		regexp.QuoteMeta(archive_extension(task)) + `(` + strings.Join(compressions, "|") + `)?(` + strings.Join(sidecars, "|") + `)?$`)
+}
+
+func backup_set_key(name, ext string) string {
+	for _, suffix := range backup_sidecar_suffixes {
+		name = strings.TrimSuffix(name, suffix)
+	}
+	return regexp.MustCompile(`\.part\d{3}`+regexp.QuoteMeta(ext)+`$`).ReplaceAllString(name, "")
+}
+
+// backup_sets groups the task's backups in its StorePath; other files are
+// left alone. Deltas join the set of the baseline before them, as they
+// cannot be restored without it.
+func backup_sets(task BackupTask) []*backupSet {
+	// ReadDir sorts by name, and names end in a timestamp, so every delta
+	// comes after its baseline.
 	files, _ := os.ReadDir(task.StorePath)
-	if len(files) > task.MaxBackup {
-		sort.Slice(files, func(i, j int) bool {
-			modTimeI, _ := files[i].Info()
-			modTimeJ, _ := files[j].Info()
-			return modTimeI.ModTime().Before(modTimeJ.ModTime())
+	ext := archive_extension(task)
+	pattern := task_file_pattern(task)
+	index := map[string]*backupSet{}
+	var sets []*backupSet
+	baseline := ""
+	for _, file := range files {
+		if !pattern.MatchString(file.Name()) {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		key := backup_set_key(file.Name(), ext)
+		if strings.HasSuffix(key, delta_marker+ext) && baseline != "" {
+			key = baseline
+		} else if strings.HasSuffix(key, ext) {
+			baseline = key
+		}
+		set, ok := index[key]
+		if !ok {
+			set = &backupSet{key: key}
+			index[key] = set
+			sets = append(sets, set)
+		}
+		set.files = append(set.files, file.Name())
+		if info.ModTime().After(set.modTime) {
+			set.modTime = info.ModTime()
+		}
+	}
+	return sets
+}
+
+func check_backup_file_num(task BackupTask) {
+	if safe_mode {
+		return
+	}
+	sets := backup_sets(task)
+	grace, _ := time.ParseDuration(task.PruneGracePeriod)
+	if task.GFS != nil {
+		prune_gfs(task, sets, grace)
+		return
+	}
+	if len(sets) > task.MaxBackup {
+		sort.Slice(sets, func(i, j int) bool {
+			return sets[i].modTime.Before(sets[j].modTime)
 		})
-		for i := 0; i < len(files)-task.MaxBackup; i++ {
-			os.Remove(task.StorePath + "/" + files[i].Name())
+		for i := 0; i < len(sets)-task.MaxBackup; i++ {
+			// Sets are sorted oldest first, so every later set is in the grace period too.
+			if time.Since(sets[i].modTime) < grace {
+				break
+			}
+			for _, name := range sets[i].files {
+				os.Remove(task.StorePath + "/" + name)
+			}
 		}
 	}
 }
 
-func copy_backup_to_onedrive(task BackupTask, botToken string, chatID int64, enable bool) {
-	rclone_command := "rclone sync " + task.StorePath + " " + task.OnedrivePath
-	if _, err := exec.Command("sh", "-c", rclone_command).Output(); err != nil {
+func copy_backup_to_onedrive(task BackupTask, botToken string, chatID int64, enable bool) error {
+	rclone := shell_quote(binary(task.RclonePath, "rclone"))
+	rclone_command := rclone + " sync " + task.StorePath + " " + task.RemotePath
+	if task.StreamUpload || safe_mode {
+		// The remote also holds streamed dumps with no local copy, which
+		// sync would delete, and SafeMode deletes nothing.
+		rclone_command = rclone + " copy " + task.StorePath + " " + task.RemotePath
+	}
+	if task.RemoteRetentionTag != "" {
+		// The bucket's lifecycle rules expire tagged backups, so never
+		// delete on the remote.
+		rclone_command = rclone + " copy " + task.StorePath + " " + task.RemotePath +
+			" --header-upload " + shell_quote("X-Amz-Tagging: "+task.RemoteRetentionTag)
+	}
+	// Another task may be writing into the same StorePath.
+	rclone_command += partial_filter
+	if task.VerifyUpload {
+		rclone_command += " --checksum"
+	}
+	bar := new_progress_bar("Uploading "+task_name(task), 100)
+	var report func(stats string)
+	if interval, err := time.ParseDuration(task.UploadProgressInterval); err == nil && interval > 0 {
+		// NOTICE gets the stats logged without the rest of -v.
+		rclone_command += " --stats " + interval.String() + " --stats-one-line --stats-log-level NOTICE"
+		report = func(stats string) {
+			log.Printf("Uploading %s to %s: %s", task_name(task), task.RemotePath, stats)
+		}
+	} else if bar != nil {
+		rclone_command += " --stats 1s --stats-one-line -v"
+	}
+	output, err := rclone_output(run_command("sh", "-c", rclone_command), bar, report)
+	bar.finish()
+	if err != nil {
+		if rclone_auth_failed(string(output)) {
+			remote := strings.SplitN(task.RemotePath, ":", 2)[0]
+			send_message(botToken, chatID, "rclone remote needs re-authentication: "+task.RemotePath+"\nRun: rclone config reconnect "+remote+":", enable)
+			return err
+		}
 		send_message(botToken, chatID, "Copy to onedrive FAILED: "+task.StorePath, enable)
+		return err
+	}
+	if task.VerifyUpload {
+		check_command := rclone + " check " + task.StorePath + " " + task.RemotePath + " --one-way" + partial_filter
+		output, err := run_command("sh", "-c", check_command).CombinedOutput()
+		if err != nil || rclone_check_failed(string(output)) {
+			send_message(botToken, chatID, "Verify onedrive upload FAILED: "+task.StorePath, enable)
+			return errors.New("rclone check found differences")
+		}
 	}
+	if task.StreamUpload {
+		if err := prune_remote(task); err != nil {
+			log.Printf("Error pruning %s: %v", task.RemotePath, err)
+		}
+	}
+	return nil
+}
+
+var rclone_auth_errors = []string{
+	"token expired",
+	"invalid_grant",
+	"InvalidAuthenticationToken",
+	"couldn't fetch token",
+	"failed to refresh token",
+	"401 Unauthorized",
+	"HTTP error 401",
+}
+
+// rclone_auth_failed reports whether rclone output shows that the remote's
+// credentials were rejected rather than a transfer error.
+func rclone_auth_failed(output string) bool {
+	lower := strings.ToLower(output)
+	for _, pattern := range rclone_auth_errors {
+		if strings.Contains(lower, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
 }
 
-func handle_task(task BackupTask, botToken string, chatID int64, enable bool, backupFunc func(BackupTask, string, int64, bool)) {
-	backupFunc(task, botToken, chatID, enable)
+var rclone_differences_pattern = regexp.MustCompile(`(\d+) differences found`)
+
+// rclone_check_failed reports whether rclone check output lists any file
+// that differs from or is missing on the remote.
+func rclone_check_failed(output string) bool {
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Contains(line, "ERROR :") {
+			return true
+		}
+		if match := rclone_differences_pattern.FindStringSubmatch(line); match != nil && match[1] != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// handle_task runs one task end to end and returns the first failure, which
+// has already been notified.
+func handle_task(task BackupTask, botToken string, chatID int64, enable bool, backupFunc func(BackupTask, string, int64, bool) ([]string, error)) error {
+	stop_progress := notify_progress(task, botToken, chatID, enable)
+	defer stop_progress()
+	if task.EmbedRunLog {
+		task.runlog = &runLog{}
+		task.runlog.printf("goBack backup of %s", task_name(task))
+	}
+
+	thaw, err := join_freeze(task)
+	defer thaw()
+	if err != nil {
+		return err
+	}
+	if task.Elasticsearch == "" {
+		if err := check_inodes(task, botToken, chatID, enable); err != nil {
+			return err
+		}
+	}
+	start := time.Now()
+	files, err := backupFunc(task, botToken, chatID, enable)
+	thaw()
+	took := time.Since(start)
+	if err != nil && run_ctx.Err() != nil {
+		remove_backup_files(files)
+		return err
+	}
+	if errors.Is(err, errUnchanged) {
+		log.Printf("Skipping %s: %v", task_name(task), err)
+		return nil
+	}
+	if err == nil && task.AlertOnSlowdown && task.Elasticsearch == "" {
+		check_slowdown(task, took, botToken, chatID, enable)
+	}
+	if task.Elasticsearch != "" {
+		// The snapshot is kept by the cluster; only its retention is ours.
+		if err == nil {
+			if err := prune_snapshots(task); err != nil {
+				log.Printf("Error pruning snapshots of %s: %v", task.Name, err)
+			}
+		}
+		return err
+	}
+	if err == nil && task.StreamUpload && len(files) == 0 {
+		// The dump went straight to the remote; there is nothing local to rotate or sync.
+		if err := prune_remote(task); err != nil {
+			log.Printf("Error pruning %s: %v", task.RemotePath, err)
+		}
+		if task.RemoteQuotaAlert {
+			check_remote_quota(task, botToken, chatID, enable)
+		}
+		return nil
+	}
+	if err == nil && task.AutoVerify {
+		if err := verify_backup(task, files, botToken, chatID, enable); err != nil {
+			// Neither prune nor upload: the previous backups are the good ones.
+			return err
+		}
+	}
+	if err == nil && run_dedup != nil && !task.DeltaMode && len(files) > 0 {
+		if original, ok := run_dedup.claim(task_name(task), files); ok {
+			log.Printf("Backup of %s is identical to this run's backup of %s, not keeping a second copy", task_name(task), original)
+			remove_backup_files(files)
+			return nil
+		}
+	}
+	if err == nil {
+		if err := write_config_snapshot(files); err != nil {
+			log.Printf("Error writing config snapshot for %s: %v", task_name(task), err)
+		}
+		if err := write_run_log(task, files); err != nil {
+			log.Printf("Error writing run log for %s: %v", task_name(task), err)
+		}
+	}
+	if err == nil && task.DevicePath != "" {
+		err = copy_backup_to_device(task, files, botToken, chatID, enable)
+	}
 	check_backup_file_num(task)
-	copy_backup_to_onedrive(task, botToken, chatID, enable)
+	if err == nil && len(task.StorePaths) > 0 {
+		err = copy_backup_to_mirrors(task, files, botToken, chatID, enable)
+	}
+	upload_err := copy_backup_to_onedrive(task, botToken, chatID, enable)
+	if upload_err == nil && task.RemoteQuotaAlert {
+		check_remote_quota(task, botToken, chatID, enable)
+	}
+	if upload_err == nil && task.VerifyRemoteCount {
+		upload_err = verify_remote_count(task, botToken, chatID, enable)
+	}
+	if err == nil {
+		err = upload_err
+	}
+	return err
+}
+
+// backups_paused reports whether the config's PauseFile exists.
+func backups_paused(config Config) bool {
+	if config.PauseFile == "" {
+		return false
+	}
+	_, err := os.Stat(config.PauseFile)
+	return err == nil
+}
+
+// exit_code picks the process exit code from how many of total tasks failed.
+func exit_code(config Config, failed, total int) int {
+	failure := 1
+	if config.FailureExitCode != nil {
+		failure = *config.FailureExitCode
+	}
+	switch {
+	case failed == 0:
+		return config.SuccessExitCode
+	case failed < total && config.PartialExitCode != nil:
+		return *config.PartialExitCode
+	default:
+		return failure
+	}
 }
 
 func main() {
 	configPath := flag.String("c", "", "Path to the configuration file")
+	restorePath := flag.String("restore", "", "Restore this backup archive, replaying its delta chain, instead of running backups")
+	restoreDest := flag.String("dest", ".", "Directory to restore into with -restore")
+	restoreGlob := flag.String("path", "", "Only restore archive entries matching this glob, e.g. site/wp-config.php, with -restore")
+	taskName := flag.String("task", "", "Task whose PostRestoreCheck must pass after -restore, or to repair with -repair-remote (needs -c)")
+	catalog := flag.Bool("catalog", false, "List the backups of every task instead of running backups")
+	catalogFormat := flag.String("format", "table", "Output format for -catalog: table or json")
+	migrate := flag.Bool("config-migrate", false, "Upgrade the -c config file to the current format instead of running backups")
+	migrateOutput := flag.String("o", "", "Where -config-migrate writes the upgraded config (default: in place, keeping a .bak copy)")
+	catalogRemote := flag.Bool("remote", false, "Include the remotes in -catalog, listed with rclone lsjson")
+	repairRemote := flag.Bool("repair-remote", false, "Upload the -task's local backups missing from its remote instead of running backups")
+	exportMetrics := flag.String("export-metrics", "", "After the run, write Prometheus metrics to this .prom file for the node_exporter textfile collector")
+	showEffective := flag.Bool("show-config-effective", false, "Print every task's settings after applying Defaults instead of running backups")
 	flag.Parse()
 
+	if *restorePath != "" && *taskName == "" {
+		if err := restore_backup(*restorePath, *restoreDest, *restoreGlob); err != nil {
+			log.Fatalf("Error restoring backup: %v", err)
+		}
+		return
+	}
+
 	if *configPath == "" {
 		fmt.Println("Please provide a configuration file with the -c flag")
 		os.Exit(1)
@@ -176,27 +975,206 @@ func main() {
 		log.Fatalf("Error unmarshalling config file: %v", err)
 	}
 
+	if *migrate {
+		if err := migrate_config(*configPath, *migrateOutput, config); err != nil {
+			log.Fatalf("Error migrating config file: %v", err)
+		}
+		return
+	}
+	if config.SecretsFile != "" {
+		if err := load_secrets(*configPath, &config); err != nil {
+			log.Fatalf("Error reading secrets file: %v", err)
+		}
+	}
+	if err := apply_defaults(configFile, &config); err != nil {
+		log.Fatalf("Error in config file: %v", err)
+	}
+	normalize_config(&config)
+	if *showEffective {
+		if err := print_effective_config(config); err != nil {
+			log.Fatalf("Error printing config: %v", err)
+		}
+		return
+	}
+	for _, task := range all_tasks(config) {
+		if err := validate_task(task); err != nil {
+			log.Fatalf("Error in config file: %v", err)
+		}
+	}
+	for _, task := range config.ElasticsearchTasks {
+		if task.Name == "" || task.Elasticsearch == "" || task.SnapshotRepository == "" {
+			log.Fatalf("Error in config file: Elasticsearch tasks need Name, Elasticsearch and SnapshotRepository")
+		}
+	}
+	if err := validate_freeze_groups(config); err != nil {
+		log.Fatalf("Error in config file: %v", err)
+	}
+	if message_catalog, err = load_catalog(*configPath, config); err != nil {
+		log.Fatalf("Error in config file: %v", err)
+	}
+	if config.IncludeConfigSnapshot {
+		if config_snapshot, err = mask_config(config); err != nil {
+			log.Fatalf("Error in config file: %v", err)
+		}
+	}
+	if config.CheckClock != nil {
+		if err := validate_clock_check(*config.CheckClock); err != nil {
+			log.Fatalf("Error in config file: %v", err)
+		}
+	}
+	if config.Telegram.QueueFile != "" && !filepath.IsAbs(config.Telegram.QueueFile) {
+		config.Telegram.QueueFile = filepath.Join(filepath.Dir(*configPath), config.Telegram.QueueFile)
+	}
+	if telegram_queue, err = new_message_queue(config.Telegram); err != nil {
+		log.Fatalf("Error in config file: %v", err)
+	}
+
+	if *restorePath != "" {
+		if err := restore_task(config, *taskName, *restorePath, *restoreDest, *restoreGlob); err != nil {
+			log.Fatalf("Error restoring backup: %v", err)
+		}
+		return
+	}
+
+	if *repairRemote {
+		if *taskName == "" {
+			log.Fatalf("-repair-remote needs -task")
+		}
+		if err := repair_remote(config, *taskName); err != nil {
+			log.Fatalf("Error repairing remote: %v", err)
+		}
+		return
+	}
+
+	if *catalog {
+		if err := print_catalog(config, *catalogFormat, *catalogRemote); err != nil {
+			log.Fatalf("Error building catalog: %v", err)
+		}
+		return
+	}
+
+	var run_deadline time.Time
+	if config.RunTimeout != "" {
+		limit, err := time.ParseDuration(config.RunTimeout)
+		if err != nil {
+			log.Fatalf("Error in config file: invalid RunTimeout %q: %v", config.RunTimeout, err)
+		}
+		run_deadline = time.Now().Add(limit)
+		var cancel context.CancelFunc
+		run_ctx, cancel = context.WithDeadline(run_ctx, run_deadline)
+		defer cancel()
+		watch_run_timeout(config, limit)
+	}
+
+	if config.Telegram.Enable {
+		telegram_queue.flush(config.Telegram.BotToken)
+	}
+
+	if backups_paused(config) {
+		log.Printf("Backups paused: %s exists", config.PauseFile)
+		os.Exit(exit_code(config, 0, 0))
+	}
+
+	if config.SkipOnBattery && on_battery() {
+		log.Print("Backups skipped: running on battery")
+		os.Exit(exit_code(config, 0, 0))
+	}
+
+	if config.CheckClock != nil {
+		if err := check_clock(*config.CheckClock, config.Telegram.BotToken, config.Telegram.ChatID, config.Telegram.Enable); err != nil {
+			log.Printf("Not running backups: %v", err)
+			os.Exit(exit_code(config, 1, 1))
+		}
+	}
+
+	if config.MaxRunDuration != "" {
+		limit, err := time.ParseDuration(config.MaxRunDuration)
+		if err != nil {
+			log.Fatalf("Error in config file: invalid MaxRunDuration %q: %v", config.MaxRunDuration, err)
+		}
+		var cancel context.CancelFunc
+		run_ctx, cancel = context.WithTimeout(run_ctx, limit)
+		defer cancel()
+	}
+
+	if config.SafeMode {
+		safe_mode = true
+		log.Print("SafeMode is on: no backup will be rotated, pruned or deleted from a remote")
+	}
+
+	if config.DedupIdentical {
+		run_dedup = &runDedup{seen: map[string]string{}}
+	}
+
+	for _, task := range all_tasks(config) {
+		heal_backup_sets(task)
+	}
+
+	freeze_groups(config)
+
 	var wg sync.WaitGroup
-	for _, task := range config.WebsiteTasks {
+	var failed int32
+	tracker := &runTracker{finished: map[string]bool{}}
+	var metrics *runMetrics
+	if *exportMetrics != "" {
+		metrics = &runMetrics{start: time.Now(), results: map[string]taskResult{}}
+	}
+	var names []string
+	run := func(task BackupTask, backupFunc func(BackupTask, string, int64, bool) ([]string, error)) {
+		names = append(names, task_name(task))
 		wg.Add(1)
-		go func(task BackupTask) {
+		go func() {
 			defer wg.Done()
-			handle_task(task, config.Telegram.BotToken, config.Telegram.ChatID, config.Telegram.Enable, backup_website)
-		}(task)
+			start := time.Now()
+			err := handle_task(task, config.Telegram.BotToken, config.Telegram.ChatID, config.Telegram.Enable, backupFunc)
+			metrics.record(task_name(task), err, time.Since(start))
+			if err != nil {
+				atomic.AddInt32(&failed, 1)
+			}
+			tracker.finish(task_name(task), err)
+		}()
+	}
+	for _, task := range config.WebsiteTasks {
+		run(task, backup_website)
 	}
 	for _, task := range config.DatabaseTasks {
-		wg.Add(1)
-		go func(task BackupTask) {
-			defer wg.Done()
-			handle_task(task, config.Telegram.BotToken, config.Telegram.ChatID, config.Telegram.Enable, backup_database)
-		}(task)
+		run(task, backup_database)
 	}
 	for _, task := range config.ConfigTasks {
-		wg.Add(1)
-		go func(task BackupTask) {
-			defer wg.Done()
-			handle_task(task, config.Telegram.BotToken, config.Telegram.ChatID, config.Telegram.Enable, backup_config)
-		}(task)
+		run(task, backup_config)
+	}
+	for _, task := range config.ElasticsearchTasks {
+		run(task, backup_elasticsearch)
+	}
+	wait_run(&wg)
+	// Tasks still running after run_cancel_grace have not thawed yet.
+	thaw_all()
+
+	total := len(names)
+	failed_tasks := int(atomic.LoadInt32(&failed))
+	timed_out := false
+	if run_ctx.Err() != nil {
+		cut_failed, cut := tracker.failed(names)
+		if len(cut) > 0 {
+			reason := "stopped after MaxRunDuration " + config.MaxRunDuration
+			if timed_out = !run_deadline.IsZero() && !time.Now().Before(run_deadline); timed_out {
+				reason = "exceeded time budget RunTimeout " + config.RunTimeout
+			}
+			report_cut_off(config, reason, names, cut)
+			failed_tasks = cut_failed
+		}
+	}
+	code := exit_code(config, failed_tasks, total)
+	if timed_out {
+		code = timeout_exit_code(config)
+	}
+	if config.IncludeDiskUsage {
+		report_summary(config, failed_tasks, total)
+	}
+	if metrics != nil {
+		if err := write_metrics(*exportMetrics, config, metrics, code); err != nil {
+			log.Printf("Error writing metrics: %v", err)
+		}
 	}
-	wg.Wait()
+	os.Exit(code)
 }
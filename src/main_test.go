@@ -0,0 +1,510 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestTaskFilePattern(t *testing.T) {
+	site := BackupTask{Website: "site"}
+	shop := BackupTask{Database: "shop"}
+	tests := []struct {
+		task BackupTask
+		name string
+		want bool
+	}{
+		{site, "site-20261014-100000.zip", true},
+		{site, "site-20261014-100000.part002.zip", true},
+		{site, "site-20261014-100000.delta.zip", true},
+		{site, "site-20261014-100000.zip" + file_list_suffix, true},
+		{site, "site-20261014-100000.zip" + run_log_suffix, true},
+		{site, "site-staging-20261014-100000.zip", false},
+		{site, "site-20261014-100000.zip.bak", false},
+		{site, "site-notes.txt", false},
+		{site, ".site-20261014-100000.zip.tmp", false},
+		{shop, "shop-20261014-100000.sql", true},
+		{shop, "shop-20261014-100000.sql.zst", true},
+		{shop, "shop-20261014-100000-schema.sql", true},
+		{shop, "shop-eu-20261014-100000.sql", false},
+		{BackupTask{Website: "site", Extension: ".bak"}, "site-20261014-100000.bak", true},
+		{BackupTask{Website: "site", Solid: "xz"}, "site-20261014-100000.tar.xz", true},
+	}
+	for _, tt := range tests {
+		if got := task_file_pattern(tt.task).MatchString(tt.name); got != tt.want {
+			t.Errorf("task %s, %s: got %v, want %v", task_name(tt.task), tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRotationLeavesOtherTasksAlone(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		"site-20261011-100000.zip",
+		"site-20261012-100000.zip",
+		"site-20261013-100000.zip",
+		"site-staging-20261014-100000.zip",
+		"site-staging-20261015-100000.zip",
+		"site-notes.txt",
+	}
+	for i, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modified := time.Date(2026, 10, 11+i, 10, 0, 0, 0, time.UTC)
+		os.Chtimes(path, modified, modified)
+	}
+	check_backup_file_num(BackupTask{Website: "site", StorePath: dir, MaxBackup: 2})
+
+	entries, _ := os.ReadDir(dir)
+	var kept []string
+	for _, entry := range entries {
+		kept = append(kept, entry.Name())
+	}
+	sort.Strings(kept)
+	want := []string{
+		"site-20261012-100000.zip",
+		"site-20261013-100000.zip",
+		"site-notes.txt",
+		"site-staging-20261014-100000.zip",
+		"site-staging-20261015-100000.zip",
+	}
+	if len(kept) != len(want) {
+		t.Fatalf("kept %v, want %v", kept, want)
+	}
+	for i := range want {
+		if kept[i] != want[i] {
+			t.Fatalf("kept %v, want %v", kept, want)
+		}
+	}
+}
+
+func TestRcloneCheckFailed(t *testing.T) {
+	tests := []struct {
+		output string
+		failed bool
+	}{
+		{"NOTICE: Local file system: 0 differences found\nNOTICE: 3 matching files", false},
+		{"NOTICE: Local file system: 1 differences found", true},
+		{"ERROR : site-20261014-100000.zip: file not in remote", true},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := rclone_check_failed(tt.output); got != tt.failed {
+			t.Errorf("rclone_check_failed(%q) = %v, want %v", tt.output, got, tt.failed)
+		}
+	}
+}
+
+func TestVerifyUpload(t *testing.T) {
+	tests := []struct {
+		name   string
+		check  string // what the fake rclone check prints and exits with
+		failed bool
+	}{
+		{"remote matches", "echo '0 differences found' >&2", false},
+		{"remote differs", "echo '1 differences found' >&2; exit 1", true},
+		{"differences without an exit code", "echo 'ERROR : a.zip: sizes differ' >&2", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			rclone := filepath.Join(dir, "rclone")
+			script := "#!/bin/sh\necho \"$@\" >> " + filepath.Join(dir, "args") + "\n" +
+				"if [ \"$1\" = check ]; then " + tt.check + "; fi\n"
+			if err := os.WriteFile(rclone, []byte(script), 0755); err != nil {
+				t.Fatal(err)
+			}
+			task := BackupTask{Website: "site", StorePath: dir, RemotePath: "r:site", RclonePath: rclone, VerifyUpload: true}
+			err := copy_backup_to_onedrive(task, "", 0, false)
+			if (err != nil) != tt.failed {
+				t.Fatalf("err = %v, want failure %v", err, tt.failed)
+			}
+			args, _ := os.ReadFile(filepath.Join(dir, "args"))
+			if !strings.Contains(string(args), "sync "+dir+" r:site") || !strings.Contains(string(args), "--checksum") {
+				t.Errorf("rclone ran with %q, want a sync with --checksum", args)
+			}
+		})
+	}
+}
+
+func TestRemoteRetentionTag(t *testing.T) {
+	tests := []struct {
+		name    string
+		task    BackupTask
+		command string // the rclone command the upload runs
+		header  bool
+	}{
+		{"untagged", BackupTask{}, "sync", false},
+		{"tagged", BackupTask{RemoteRetentionTag: "retention=90d"}, "copy", true},
+		{"tagged stream", BackupTask{StreamUpload: true, RemoteRetentionTag: "retention=90d"}, "rcat", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			rclone := filepath.Join(dir, "rclone")
+			script := "#!/bin/sh\necho \"$@\" >> " + filepath.Join(dir, "args") + "\n[ \"$1\" = rcat ] && cat > /dev/null\nexit 0\n"
+			os.WriteFile(rclone, []byte(script), 0755)
+			mysqldump, _ := fake_mysql(t, dir)
+
+			task := tt.task
+			task.Database, task.StorePath, task.RemotePath, task.RclonePath, task.MysqldumpPath, task.MaxBackup = "shop", dir, "r:shop", rclone, mysqldump, 1
+			if err := validate_task(task); err != nil {
+				t.Fatal(err)
+			}
+			if err := handle_task(task, "", 0, false, backup_database); err != nil {
+				t.Fatal(err)
+			}
+			args, _ := os.ReadFile(filepath.Join(dir, "args"))
+			lines := strings.Split(strings.TrimSpace(string(args)), "\n")
+			if !strings.HasPrefix(lines[0], tt.command+" ") {
+				t.Errorf("rclone ran %q, want %s", lines[0], tt.command)
+			}
+			if got := strings.Contains(lines[0], "--header-upload X-Amz-Tagging: retention=90d"); got != tt.header {
+				t.Errorf("rclone ran %q, tagged %v, want %v", lines[0], got, tt.header)
+			}
+			// The bucket's lifecycle rules expire tagged backups, so nothing
+			// lists or deletes on the remote.
+			if tt.header && len(lines) != 1 {
+				t.Errorf("rclone also ran %q", lines[1:])
+			}
+		})
+	}
+
+	if err := validate_task(BackupTask{Website: "site", RemoteRetentionTag: "90d"}); err == nil {
+		t.Error("validate_task accepted a RemoteRetentionTag without key=value")
+	}
+}
+
+func TestRcloneAuthFailed(t *testing.T) {
+	tests := []struct {
+		output string
+		auth   bool
+	}{
+		{`Failed to create file system for "onedrive:": couldn't fetch token - maybe it has expired? - refresh with "rclone config reconnect onedrive:"`, true},
+		{"ERROR : oauth2: cannot fetch token: 400 Bad Request\nResponse: {\"error\":\"invalid_grant\"}", true},
+		{"ERROR : Attempt 1/3 failed with 1 errors and: InvalidAuthenticationToken: Access token has expired", true},
+		{"HTTP ERROR 401: unauthorized", true},
+		{"ERROR : site-20261014-100000.zip: Failed to copy: context deadline exceeded", false},
+		{"Failed to sync: directory not found", false},
+	}
+	for _, tt := range tests {
+		if got := rclone_auth_failed(tt.output); got != tt.auth {
+			t.Errorf("rclone_auth_failed(%q) = %v, want %v", tt.output, got, tt.auth)
+		}
+	}
+}
+
+// write_backups creates the named files in dir, each modified age ago.
+func write_backups(t *testing.T, dir string, ages map[string]time.Duration) {
+	t.Helper()
+	for name, age := range ages {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modified := time.Now().Add(-age)
+		os.Chtimes(path, modified, modified)
+	}
+}
+
+// remaining lists the files left in dir, sorted.
+func remaining(dir string) []string {
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestPruneGracePeriod(t *testing.T) {
+	ages := map[string]time.Duration{
+		"site-20261011-100000.zip": 72 * time.Hour,
+		"site-20261012-100000.zip": 50 * time.Hour,
+		"site-20261014-090000.zip": time.Hour,
+		"site-20261014-093000.zip": 30 * time.Minute,
+	}
+	tests := []struct {
+		grace string
+		kept  []string
+	}{
+		{"", []string{"site-20261014-093000.zip"}},
+		{"2h", []string{"site-20261014-090000.zip", "site-20261014-093000.zip"}},
+		{"60h", []string{"site-20261012-100000.zip", "site-20261014-090000.zip", "site-20261014-093000.zip"}},
+		{"100h", []string{"site-20261011-100000.zip", "site-20261012-100000.zip", "site-20261014-090000.zip", "site-20261014-093000.zip"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.grace, func(t *testing.T) {
+			dir := t.TempDir()
+			write_backups(t, dir, ages)
+			task := BackupTask{Website: "site", StorePath: dir, MaxBackup: 1, PruneGracePeriod: tt.grace}
+			if err := validate_task(task); err != nil {
+				t.Fatal(err)
+			}
+			check_backup_file_num(task)
+			if got := remaining(dir); strings.Join(got, ",") != strings.Join(tt.kept, ",") {
+				t.Errorf("kept %v, want %v", got, tt.kept)
+			}
+		})
+	}
+	if err := validate_task(BackupTask{Website: "site", PruneGracePeriod: "2 days"}); err == nil {
+		t.Error("an invalid PruneGracePeriod was accepted")
+	}
+}
+
+func TestPathWithin(t *testing.T) {
+	tests := []struct {
+		path, dir string
+		within    bool
+	}{
+		{"/srv/site/backups", "/srv/site/backups", true},
+		{"/srv/site/backups/site-20261014-100000.zip", "/srv/site/backups", true},
+		{"/srv/site/backups-old/a.zip", "/srv/site/backups", false},
+		{"/srv/site", "/srv/site/backups", false},
+		{"/srv/site/backups/../index.html", "/srv/site/backups", false},
+	}
+	for _, tt := range tests {
+		if got := path_within(tt.path, tt.dir); got != tt.within {
+			t.Errorf("path_within(%q, %q) = %v, want %v", tt.path, tt.dir, got, tt.within)
+		}
+	}
+}
+
+// A StorePath inside BackupSource must never end up in its own archive.
+func TestStorePathNotArchived(t *testing.T) {
+	tests := []struct {
+		name string
+		task BackupTask
+	}{
+		{"plain", BackupTask{}},
+		{"split", BackupTask{SplitBySize: 1 << 20}},
+		{"parallel", BackupTask{ArchiveWorkers: 2}},
+		{"delta", BackupTask{DeltaMode: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := filepath.Join(t.TempDir(), "site")
+			store := filepath.Join(source, "backups")
+			os.MkdirAll(store, 0755)
+			os.WriteFile(filepath.Join(source, "index.html"), []byte("<html>"), 0644)
+			os.WriteFile(filepath.Join(store, "site-20261013-100000.zip"), []byte("old backup"), 0644)
+
+			task := tt.task
+			task.Website, task.BackupSource, task.StorePath = "site", source, store
+			files, err := archive_source(task, filepath.Join(store, "site-20261014-100000.zip"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(zip_names(t, files[0]), ","); got != "site/,site/index.html" {
+				t.Errorf("archive holds %s, want only the site", got)
+			}
+		})
+	}
+}
+
+func TestBackupsPaused(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "maintenance"), nil, 0644)
+	tests := []struct {
+		pause_file string
+		paused     bool
+	}{
+		{"", false},
+		{filepath.Join(dir, "maintenance"), true},
+		{filepath.Join(dir, "missing"), false},
+		{dir, true},
+	}
+	for _, tt := range tests {
+		if got := backups_paused(Config{PauseFile: tt.pause_file}); got != tt.paused {
+			t.Errorf("backups_paused with PauseFile %q = %v, want %v", tt.pause_file, got, tt.paused)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	code := func(n int) *int { return &n }
+	custom := Config{SuccessExitCode: 10, FailureExitCode: code(20), PartialExitCode: code(30)}
+	tests := []struct {
+		name          string
+		config        Config
+		failed, total int
+		want          int
+	}{
+		{"defaults, all succeeded", Config{}, 0, 3, 0},
+		{"defaults, some failed", Config{}, 1, 3, 1},
+		{"defaults, all failed", Config{}, 3, 3, 1},
+		{"custom, all succeeded", custom, 0, 3, 10},
+		{"custom, some failed", custom, 1, 3, 30},
+		{"custom, all failed", custom, 3, 3, 20},
+		{"no partial code", Config{FailureExitCode: code(20)}, 1, 3, 20},
+		{"failure code zero", Config{FailureExitCode: code(0)}, 1, 3, 0},
+		{"no tasks", custom, 0, 0, 10},
+	}
+	for _, tt := range tests {
+		if got := exit_code(tt.config, tt.failed, tt.total); got != tt.want {
+			t.Errorf("%s: exit_code = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// fake_mysql writes mysqldump and mysql stand-ins into dir. mysqldump logs
+// its arguments to dir/mysqldump.args and prints a dump of the database
+// named by its first argument, without rows for --no-data. mysql logs its
+// arguments to dir/mysql.log and answers SHOW MASTER STATUS with
+// dir/position.
+func fake_mysql(t *testing.T, dir string) (mysqldump, mysql string) {
+	t.Helper()
+	mysqldump = filepath.Join(dir, "mysqldump")
+	dump := `#!/bin/sh
+echo "$@" >> "` + filepath.Join(dir, "mysqldump.args") + `"
+echo "CREATE TABLE $1 (id int);"
+case " $* " in *" --no-data "*) ;; *) echo "INSERT INTO $1 VALUES (1);";; esac
+`
+	mysql = filepath.Join(dir, "mysql")
+	client := `#!/bin/sh
+echo "$@" >> "` + filepath.Join(dir, "mysql.log") + `"
+case "$*" in *"SHOW MASTER STATUS"*) cat "` + filepath.Join(dir, "position") + `" 2>/dev/null;; esac
+`
+	if err := os.WriteFile(mysqldump, []byte(dump), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mysql, []byte(client), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return mysqldump, mysql
+}
+
+func TestSchemaOnly(t *testing.T) {
+	tests := []struct {
+		schema_only bool
+		suffix      string
+		dump        string
+	}{
+		{false, ".sql", "CREATE TABLE shop (id int);\nINSERT INTO shop VALUES (1);\n"},
+		{true, "-schema.sql", "CREATE TABLE shop (id int);\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.suffix, func(t *testing.T) {
+			dir := t.TempDir()
+			mysqldump, mysql := fake_mysql(t, dir)
+			task := BackupTask{Database: "shop", StorePath: dir, MysqldumpPath: mysqldump, MysqlPath: mysql, SchemaOnly: tt.schema_only}
+			files, err := backup_database(task, "", 0, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 || !strings.HasSuffix(files[0], tt.suffix) || !task_file_pattern(task).MatchString(filepath.Base(files[0])) {
+				t.Fatalf("files = %v, want one ending in %s", files, tt.suffix)
+			}
+			if tt.schema_only == false && strings.HasSuffix(files[0], "-schema.sql") {
+				t.Errorf("full dump %s named as a schema dump", files[0])
+			}
+			data, _ := os.ReadFile(files[0])
+			if string(data) != tt.dump {
+				t.Errorf("dump = %q, want %q", data, tt.dump)
+			}
+		})
+	}
+}
+
+func TestBackupTimestamp(t *testing.T) {
+	now := time.Date(2026, 10, 14, 22, 30, 5, 0, time.UTC)
+	tests := []struct {
+		timezone string
+		want     string
+	}{
+		{"", "20261014-223005"},
+		{"UTC", "20261014-223005"},
+		{"Europe/Berlin", "20261015-003005"},
+		{"America/New_York", "20261014-183005"},
+		{"Asia/Kolkata", "20261015-040005"},
+	}
+	for _, tt := range tests {
+		task := BackupTask{Website: "site", Timezone: tt.timezone}
+		if err := validate_task(task); err != nil {
+			t.Fatalf("%s: %v", tt.timezone, err)
+		}
+		if got := backup_timestamp(task, now); got != tt.want {
+			t.Errorf("backup_timestamp in %q = %s, want %s", tt.timezone, got, tt.want)
+		}
+	}
+	if err := validate_task(BackupTask{Website: "site", Timezone: "Mars/Olympus"}); err == nil {
+		t.Error("an unknown Timezone was accepted")
+	}
+}
+
+func TestSingleFileSource(t *testing.T) {
+	tests := []struct {
+		name string
+		task BackupTask
+	}{
+		{"plain", BackupTask{}},
+		{"split", BackupTask{SplitBySize: 1}},
+		{"delta", BackupTask{DeltaMode: true}},
+		{"parallel", BackupTask{ArchiveWorkers: 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, "nginx.conf")
+			os.WriteFile(source, []byte("worker_processes 4;\n"), 0644)
+			task := tt.task
+			task.Name, task.BackupSource, task.StorePath = "nginx", source, dir
+			target := filepath.Join(dir, "nginx-20261014-100000.zip")
+			files, err := archive_source(task, target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 || files[0] != target {
+				t.Fatalf("files = %v, want [%s]", files, target)
+			}
+			if got := strings.Join(zip_names(t, target), ","); got != "nginx.conf" {
+				t.Errorf("archive holds %s, want nginx.conf", got)
+			}
+			dest := filepath.Join(dir, "restored")
+			if err := restore_backup(target, dest, ""); err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := os.ReadFile(filepath.Join(dest, "nginx.conf")); string(data) != "worker_processes 4;\n" {
+				t.Errorf("restored %q", data)
+			}
+		})
+	}
+}
+
+// recordingBot stands in for Telegram and keeps the messages sent.
+type recordingBot struct {
+	mu   sync.Mutex
+	sent []string
+}
+
+func (b *recordingBot) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sent = append(b.sent, c.(tgbotapi.MessageConfig).Text)
+	return tgbotapi.Message{}, nil
+}
+
+func (b *recordingBot) messages() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.sent...)
+}
+
+// record_messages sends the test's Telegram messages to a recordingBot.
+func record_messages(t *testing.T) *recordingBot {
+	t.Helper()
+	bot := &recordingBot{}
+	saved := new_telegram_bot
+	new_telegram_bot = func(string) (telegramBot, error) { return bot, nil }
+	t.Cleanup(func() { new_telegram_bot = saved })
+	return bot
+}
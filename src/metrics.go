@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runMetrics collects how each task of the run went for -export-metrics.
+type runMetrics struct {
+	mu      sync.Mutex
+	start   time.Time
+	results map[string]taskResult
+}
+
+type taskResult struct {
+	ok   bool
+	took time.Duration
+}
+
+func (m *runMetrics) record(name string, err error, took time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results[name] = taskResult{ok: err == nil, took: took}
+}
+
+func metric_label(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// format_metrics renders the run in the Prometheus text format. A task
+// that did not finish, e.g. one cut off by MaxRunDuration, counts as
+// failed and has no duration.
+func format_metrics(config Config, m *runMetrics, now time.Time, exit int) string {
+	var b strings.Builder
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	tasks := all_tasks(config)
+
+	metric("goback_task_success", "gauge", "Whether the task succeeded in the last run.")
+	for _, task := range tasks {
+		success := 0
+		if m.results[task_name(task)].ok {
+			success = 1
+		}
+		fmt.Fprintf(&b, "goback_task_success{task=\"%s\"} %d\n", metric_label(task_name(task)), success)
+	}
+	metric("goback_task_duration_seconds", "gauge", "How long the task took in the last run.")
+	for _, task := range tasks {
+		if result, ok := m.results[task_name(task)]; ok {
+			fmt.Fprintf(&b, "goback_task_duration_seconds{task=\"%s\"} %.3f\n", metric_label(task_name(task)), result.took.Seconds())
+		}
+	}
+	metric("goback_last_backup_timestamp_seconds", "gauge", "When the newest local backup of the task was made.")
+	var sizes []string
+	for _, task := range tasks {
+		entries := local_catalog(task)
+		if len(entries) == 0 {
+			continue
+		}
+		newest := entries[0]
+		for _, e := range entries[1:] {
+			if e.Time.After(newest.Time) {
+				newest = e
+			}
+		}
+		label := metric_label(task_name(task))
+		fmt.Fprintf(&b, "goback_last_backup_timestamp_seconds{task=\"%s\"} %d\n", label, newest.Time.Unix())
+		sizes = append(sizes, fmt.Sprintf("goback_last_backup_size_bytes{task=\"%s\"} %d\n", label, newest.Size))
+	}
+	metric("goback_last_backup_size_bytes", "gauge", "Size of the newest local backup of the task.")
+	b.WriteString(strings.Join(sizes, ""))
+
+	metric("goback_run_timestamp_seconds", "gauge", "When the run finished.")
+	fmt.Fprintf(&b, "goback_run_timestamp_seconds %d\n", now.Unix())
+	metric("goback_run_duration_seconds", "gauge", "How long the run took.")
+	fmt.Fprintf(&b, "goback_run_duration_seconds %.3f\n", now.Sub(m.start).Seconds())
+	metric("goback_run_exit_code", "gauge", "The exit code of the run.")
+	fmt.Fprintf(&b, "goback_run_exit_code %d\n", exit)
+	return b.String()
+}
+
+// write_metrics writes the run's metrics to path for the node_exporter
+// textfile collector. The file is replaced by a rename, so the collector
+// never reads it half-written.
+func write_metrics(path string, config Config, m *runMetrics, exit int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, []byte(format_metrics(config, m, time.Now(), exit)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatMetrics(t *testing.T) {
+	dir := t.TempDir()
+	write_tree(t, dir, map[string]string{
+		"site-20261013-100000.zip": "older",
+		"site-20261014-100000.zip": "newest archive",
+		"shop-20261014-100000.sql": "dump",
+	})
+	config := Config{
+		WebsiteTasks:  []BackupTask{{Website: "site", StorePath: dir}},
+		DatabaseTasks: []BackupTask{{Database: "shop", StorePath: dir}, {Database: `we"ird`, StorePath: dir}},
+	}
+	start := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	metrics := &runMetrics{start: start, results: map[string]taskResult{}}
+	metrics.record("site", nil, 1500*time.Millisecond)
+	metrics.record("shop", errors.New("mysqldump failed"), 2*time.Second)
+	var none *runMetrics
+	none.record("site", nil, time.Second)
+
+	output := format_metrics(config, metrics, start.Add(90*time.Second), 1)
+	tests := []struct {
+		line    string
+		present bool
+	}{
+		{"# TYPE goback_task_success gauge", true},
+		{`goback_task_success{task="site"} 1`, true},
+		{`goback_task_success{task="shop"} 0`, true},
+		// A task that did not finish counts as failed, with no duration.
+		{`goback_task_success{task="we\"ird"} 0`, true},
+		{`goback_task_duration_seconds{task="site"} 1.500`, true},
+		{`goback_task_duration_seconds{task="we\"ird"}`, false},
+		{`goback_last_backup_timestamp_seconds{task="site"} 1791972000`, true},
+		{`goback_last_backup_size_bytes{task="site"} 14`, true},
+		{`goback_last_backup_size_bytes{task="shop"} 4`, true},
+		{`goback_last_backup_size_bytes{task="we\"ird"}`, false},
+		{"goback_run_timestamp_seconds 1791972090", true},
+		{"goback_run_duration_seconds 90.000", true},
+		{"goback_run_exit_code 1", true},
+	}
+	lines := strings.Split(output, "\n")
+	for _, tt := range tests {
+		found := false
+		for _, line := range lines {
+			if line == tt.line || !tt.present && strings.HasPrefix(line, tt.line) {
+				found = true
+			}
+		}
+		if found != tt.present {
+			t.Errorf("metrics have %s: %v, want %v\n%s", tt.line, found, tt.present, output)
+		}
+	}
+
+	path := filepath.Join(dir, "goback.prom")
+	if err := write_metrics(path, config, metrics, 0); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "goback_run_exit_code 0\n") {
+		t.Errorf("wrote %s", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".goback.prom.tmp")); err == nil {
+		t.Error("left the temporary file behind")
+	}
+}
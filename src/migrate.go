@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// migrate_task upgrades one task and describes each change made.
+func migrate_task(task *BackupTask, path string, inherits_timezone bool) []string {
+	var changes []string
+	if task.OnedrivePath != "" {
+		if task.RemotePath == "" {
+			task.RemotePath = task.OnedrivePath
+		}
+		task.OnedrivePath = ""
+		changes = append(changes, fmt.Sprintf("~ %s: renamed OnedrivePath to RemotePath", path))
+	}
+	if task.Timezone == "" && !inherits_timezone {
+		task.Timezone = "UTC"
+		changes = append(changes, fmt.Sprintf("+ %s.Timezone: \"UTC\"", path))
+	}
+	return changes
+}
+
+// migrate_config writes config, as read from path, in the current format
+// to output, or over path keeping a .bak copy, and prints what changed.
+func migrate_config(path, output string, config Config) error {
+	var changes []string
+	inherits_timezone := false
+	if config.Defaults != nil {
+		changes = append(changes, migrate_task(config.Defaults, "Defaults", false)...)
+		inherits_timezone = true
+	}
+	groups := []struct {
+		name  string
+		tasks []BackupTask
+	}{
+		{"WebsiteTasks", config.WebsiteTasks},
+		{"DatabaseTasks", config.DatabaseTasks},
+		{"ConfigTasks", config.ConfigTasks},
+		{"ElasticsearchTasks", config.ElasticsearchTasks},
+	}
+	for _, group := range groups {
+		for i := range group.tasks {
+			changes = append(changes, migrate_task(&group.tasks[i], fmt.Sprintf("%s[%d]", group.name, i), inherits_timezone)...)
+		}
+	}
+	if config.FailureExitCode == nil {
+		failure := 1
+		config.FailureExitCode = &failure
+		changes = append(changes, "+ FailureExitCode: 1")
+	}
+
+	data, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		original, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path+".bak", original, 0600); err != nil {
+			return err
+		}
+		output = path
+	}
+	if err := os.WriteFile(output, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+
+	if len(changes) == 0 {
+		fmt.Println("Config is already up to date")
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	fmt.Println("Wrote", output)
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateTask(t *testing.T) {
+	tests := []struct {
+		name              string
+		task              BackupTask
+		inherits_timezone bool
+		want              BackupTask
+		changes           int
+	}{
+		{"up to date", BackupTask{RemotePath: "remote:site", Timezone: "Europe/Berlin"}, false, BackupTask{RemotePath: "remote:site", Timezone: "Europe/Berlin"}, 0},
+		{"OnedrivePath", BackupTask{OnedrivePath: "remote:site", Timezone: "UTC"}, false, BackupTask{RemotePath: "remote:site", Timezone: "UTC"}, 1},
+		{"OnedrivePath beside RemotePath", BackupTask{OnedrivePath: "remote:old", RemotePath: "remote:new", Timezone: "UTC"}, false, BackupTask{RemotePath: "remote:new", Timezone: "UTC"}, 1},
+		{"no Timezone", BackupTask{}, false, BackupTask{Timezone: "UTC"}, 1},
+		{"Timezone from Defaults", BackupTask{}, true, BackupTask{}, 0},
+	}
+	for _, tt := range tests {
+		task := tt.task
+		changes := migrate_task(&task, "WebsiteTasks[0]", tt.inherits_timezone)
+		if task.RemotePath != tt.want.RemotePath || task.OnedrivePath != "" || task.Timezone != tt.want.Timezone {
+			t.Errorf("%s: migrated to RemotePath %q, OnedrivePath %q, Timezone %q", tt.name, task.RemotePath, task.OnedrivePath, task.Timezone)
+		}
+		if len(changes) != tt.changes {
+			t.Errorf("%s: changes = %v, want %d", tt.name, changes, tt.changes)
+		}
+	}
+}
+
+func TestMigrateConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	original := `{"WebsiteTasks": [{"Website": "site", "OnedrivePath": "remote:site"}]}`
+	os.WriteFile(path, []byte(original), 0600)
+	var config Config
+	json.Unmarshal([]byte(original), &config)
+
+	tests := []struct {
+		name   string
+		output string
+	}{
+		{"to output", filepath.Join(dir, "migrated.json")},
+		{"in place", ""},
+	}
+	for _, tt := range tests {
+		if err := migrate_config(path, tt.output, config); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		written := tt.output
+		if written == "" {
+			written = path
+			if backup, _ := os.ReadFile(path + ".bak"); string(backup) != original {
+				t.Errorf("%s: .bak = %s, want the original", tt.name, backup)
+			}
+		}
+		data, _ := os.ReadFile(written)
+		var migrated Config
+		if err := json.Unmarshal(data, &migrated); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		task := migrated.WebsiteTasks[0]
+		if task.RemotePath != "remote:site" || task.OnedrivePath != "" || task.Timezone != "UTC" {
+			t.Errorf("%s: migrated task = %+v", tt.name, task)
+		}
+		if migrated.FailureExitCode == nil || *migrated.FailureExitCode != 1 {
+			t.Errorf("%s: FailureExitCode = %v, want 1", tt.name, migrated.FailureExitCode)
+		}
+	}
+}
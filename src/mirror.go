@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// mirror_file places a copy of source in dir under the same name: a hard
+// link when both are on the same filesystem, otherwise a copy written to a
+// temporary name and renamed, so the mirror never holds a partial file.
+func mirror_file(source, dir string) error {
+	target := filepath.Join(dir, filepath.Base(source))
+	os.Remove(target)
+	if os.Link(source, target) == nil {
+		return nil
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	temp := filepath.Join(dir, "."+filepath.Base(source)+".tmp")
+	out, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp, target)
+}
+
+// mirror_backup copies files and their sidecars into dir.
+func mirror_backup(files []string, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := mirror_file(file, dir); err != nil {
+			return err
+		}
+		for _, suffix := range backup_sidecar_suffixes {
+			if _, err := os.Stat(file + suffix); err != nil {
+				continue
+			}
+			if err := mirror_file(file+suffix, dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copy_backup_to_mirrors copies the new backup into every StorePaths
+// directory, then rotates each one on its own like StorePath.
+func copy_backup_to_mirrors(task BackupTask, files []string, botToken string, chatID int64, enable bool) error {
+	var first error
+	for _, dir := range task.StorePaths {
+		if err := mirror_backup(files, dir); err != nil {
+			log.Printf("Error copying %s to %s: %v", task_name(task), dir, err)
+			send_message(botToken, chatID, "Mirror Backup FAILED: "+dir, enable)
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		mirror := task
+		mirror.StorePath = dir
+		check_backup_file_num(mirror)
+	}
+	return first
+}
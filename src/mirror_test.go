@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopyBackupToMirrors(t *testing.T) {
+	bot := record_messages(t)
+	dir := t.TempDir()
+	store := filepath.Join(dir, "store")
+	fresh := filepath.Join(dir, "mirror", "fresh")
+	full := filepath.Join(dir, "mirror", "full")
+	broken := filepath.Join(dir, "not-a-dir")
+	os.MkdirAll(store, 0755)
+	os.MkdirAll(full, 0755)
+	os.WriteFile(broken, nil, 0644)
+	write_backups(t, full, map[string]time.Duration{
+		"site-20261012-100000.zip": 48 * time.Hour,
+		"site-20261013-100000.zip": 24 * time.Hour,
+	})
+	backup := filepath.Join(store, "site-20261014-100000.zip")
+	os.WriteFile(backup, []byte("archive"), 0644)
+	os.WriteFile(backup+file_list_suffix, []byte("list"), 0644)
+
+	task := BackupTask{Website: "site", StorePath: store, MaxBackup: 2, StorePaths: []string{fresh, broken, full}}
+	if err := copy_backup_to_mirrors(task, []string{backup}, "token", 1, true); err == nil {
+		t.Error("copy_backup_to_mirrors succeeded with an unusable mirror")
+	}
+	if got := bot.messages(); len(got) != 1 || got[0] != "Mirror Backup FAILED: "+broken {
+		t.Errorf("sent %q", got)
+	}
+
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{fresh, "site-20261014-100000.zip,site-20261014-100000.zip" + file_list_suffix},
+		{full, "site-20261013-100000.zip,site-20261014-100000.zip,site-20261014-100000.zip" + file_list_suffix},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(remaining(tt.dir), ","); got != tt.want {
+			t.Errorf("%s holds %s, want %s", filepath.Base(tt.dir), got, tt.want)
+		}
+		if data, _ := os.ReadFile(filepath.Join(tt.dir, filepath.Base(backup))); string(data) != "archive" {
+			t.Errorf("%s: mirrored archive = %q", filepath.Base(tt.dir), data)
+		}
+	}
+	// Mirrors on the same filesystem are hard links.
+	original, _ := os.Stat(backup)
+	if mirrored, _ := os.Stat(filepath.Join(fresh, filepath.Base(backup))); !os.SameFile(original, mirrored) {
+		t.Error("mirror is a copy, want a hard link")
+	}
+}
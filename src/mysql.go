@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const binlog_state_file = ".goBack-binlog.json"
+
+// errUnchanged is returned by a backup that was skipped because its source
+// has not changed since the last one.
+var errUnchanged = errors.New("unchanged since last backup")
+
+// binlog_position returns the server's current binlog file, offset and
+// executed GTID set as reported by SHOW MASTER STATUS. The position covers
+// the whole server, so a write to any database moves it.
+func binlog_position(task BackupTask) (string, error) {
+	cmd := run_command(binary(task.MysqlPath, "mysql"), "-N", "-B", "-e", "SHOW MASTER STATUS")
+	start := time.Now()
+	output, err := cmd.Output()
+	task.runlog.command(strings.Join(cmd.Args, " "), start, err, string(output))
+	if err != nil {
+		return "", err
+	}
+	position := strings.Join(strings.Fields(string(output)), " ")
+	if position == "" {
+		return "", fmt.Errorf("binary logging is disabled")
+	}
+	return position, nil
+}
+
+// load_binlog_state returns the binlog position recorded after the last
+// dump of each database in store_path.
+func load_binlog_state(store_path string) map[string]string {
+	state := map[string]string{}
+	data, err := os.ReadFile(filepath.Join(store_path, binlog_state_file))
+	if err == nil {
+		json.Unmarshal(data, &state)
+	}
+	return state
+}
+
+func save_binlog_state(store_path string, state map[string]string) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(store_path, binlog_state_file)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// binlog_mu serialises updates of the binlog state, which database tasks
+// sharing a StorePath also share.
+var binlog_mu sync.Mutex
+
+// record_binlog_position stores position as the one the database was last
+// dumped at. An empty position, i.e. SkipUnchanged off or unreadable, is not
+// recorded.
+func record_binlog_position(task BackupTask, position string) {
+	if position == "" {
+		return
+	}
+	binlog_mu.Lock()
+	defer binlog_mu.Unlock()
+	state := load_binlog_state(task.StorePath)
+	state[task.Database] = position
+	if err := save_binlog_state(task.StorePath, state); err != nil {
+		log.Printf("Error saving binlog position for %s: %v", task.Database, err)
+	}
+}
+
+// run_sql runs statements against the task's database with the mysql
+// client. Each call is its own session.
+func run_sql(task BackupTask, statements string) error {
+	cmd := run_command(binary(task.MysqlPath, "mysql"), task.Database, "-e", statements)
+	start := time.Now()
+	output, err := cmd.CombinedOutput()
+	task.runlog.command(strings.Join(cmd.Args, " "), start, err, string(output))
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSkipUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	mysqldump, mysql := fake_mysql(t, dir)
+	task := BackupTask{Database: "shop", StorePath: dir, MysqldumpPath: mysqldump, MysqlPath: mysql, SkipUnchanged: true}
+	runs := []struct {
+		position string // "" for binary logging disabled
+		dumped   bool
+	}{
+		{"binlog.000001 154", true},
+		{"binlog.000001 154", false},
+		{"binlog.000001 2048", true},
+		{"binlog.000002 4", true},
+		{"binlog.000002 4", false},
+		{"", true},
+		{"binlog.000002 4", false},
+	}
+	dumps := 0
+	for i, run := range runs {
+		os.WriteFile(filepath.Join(dir, "position"), []byte(run.position), 0644)
+		files, err := backup_database(task, "", 0, false)
+		if run.dumped {
+			if err != nil || len(files) != 1 {
+				t.Fatalf("run %d: backup_database = %v, %v, want a dump", i+1, files, err)
+			}
+			dumps++
+		} else if err != errUnchanged {
+			t.Fatalf("run %d: backup_database = %v, %v, want errUnchanged", i+1, files, err)
+		}
+		if run.position != "" {
+			if got := load_binlog_state(dir)["shop"]; got != run.position {
+				t.Errorf("run %d: recorded position %q, want %q", i+1, got, run.position)
+			}
+		}
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "mysqldump.args"))
+	if got := strings.Count(string(args), "\n"); got != dumps {
+		t.Errorf("mysqldump ran %d times, want %d", got, dumps)
+	}
+
+	// Without SkipUnchanged the position is neither read nor recorded.
+	os.Remove(filepath.Join(dir, binlog_state_file))
+	task.SkipUnchanged = false
+	if _, err := backup_database(task, "", 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if state := load_binlog_state(dir); len(state) != 0 {
+		t.Errorf("recorded %v without SkipUnchanged", state)
+	}
+}
+
+// Database tasks sharing a StorePath record their positions concurrently
+// without losing one another's.
+func TestRecordBinlogPositionConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			record_binlog_position(BackupTask{Database: fmt.Sprint("db", i), StorePath: dir}, fmt.Sprintf("mysql-bin.000001 %d", i))
+		}(i)
+	}
+	wg.Wait()
+	state := load_binlog_state(dir)
+	for i := 0; i < 20; i++ {
+		if got, want := state[fmt.Sprint("db", i)], fmt.Sprintf("mysql-bin.000001 %d", i); got != want {
+			t.Errorf("db%d recorded at %q, want %q", i, got, want)
+		}
+	}
+	if got := remaining(dir); len(got) != 1 || got[0] != binlog_state_file {
+		t.Errorf("StorePath holds %v, want only %s", got, binlog_state_file)
+	}
+}
+
+func TestDumpSQLHooks(t *testing.T) {
+	tests := []struct {
+		name    string
+		pre     string
+		post    string
+		dumped  bool
+		fails   bool
+		message string
+	}{
+		{"none", "", "", true, false, ""},
+		{"both", "FLUSH TABLES", "UNLOCK TABLES", true, false, ""},
+		{"pre fails", "FAIL pre", "UNLOCK TABLES", false, true, "Database PreDumpSQL FAILED: shop"},
+		{"post fails", "FLUSH TABLES", "FAIL post", true, false, "Database PostDumpSQL FAILED: shop"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := record_messages(t)
+			dir := t.TempDir()
+			mysqldump, mysql := fake_mysql(t, dir)
+			client := "#!/bin/sh\necho \"$*\" >> " + filepath.Join(dir, "mysql.log") + "\ncase \"$*\" in *FAIL*) echo 'ERROR 1064' >&2; exit 1;; esac\n"
+			os.WriteFile(mysql, []byte(client), 0755)
+
+			task := BackupTask{Database: "shop", StorePath: dir, MysqldumpPath: mysqldump, MysqlPath: mysql, PreDumpSQL: tt.pre, PostDumpSQL: tt.post}
+			files, err := backup_database(task, "token", 1, true)
+			if (err != nil) != tt.fails || (len(files) == 1) != tt.dumped {
+				t.Fatalf("backup_database = %v, %v", files, err)
+			}
+			if got := strings.Join(bot.messages(), ","); got != tt.message {
+				t.Errorf("sent %q, want %q", got, tt.message)
+			}
+
+			// Each statement runs in its own session against the database,
+			// PostDumpSQL only once PreDumpSQL succeeded.
+			var want []string
+			if tt.pre != "" {
+				want = append(want, "shop -e "+tt.pre)
+			}
+			if tt.post != "" && !tt.fails {
+				want = append(want, "shop -e "+tt.post)
+			}
+			log, _ := os.ReadFile(filepath.Join(dir, "mysql.log"))
+			if got := strings.TrimSuffix(string(log), "\n"); got != strings.Join(want, "\n") {
+				t.Errorf("mysql ran %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// files_open_for_writing returns the paths of the regular files some other
+// process has open for writing, found by scanning /proc/*/fd. Processes
+// whose descriptors cannot be read, e.g. other users' without root, are
+// skipped.
+func files_open_for_writing() map[string]bool {
+	open := map[string]bool{}
+	procs, _ := os.ReadDir("/proc")
+	self := strconv.Itoa(os.Getpid())
+	for _, proc := range procs {
+		pid := proc.Name()
+		if _, err := strconv.Atoi(pid); err != nil || pid == self {
+			continue
+		}
+		fds, _ := os.ReadDir(filepath.Join("/proc", pid, "fd"))
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join("/proc", pid, "fd", fd.Name()))
+			if err != nil || !strings.HasPrefix(target, "/") {
+				continue
+			}
+			if fd_writable(filepath.Join("/proc", pid, "fdinfo", fd.Name())) {
+				open[target] = true
+			}
+		}
+	}
+	return open
+}
+
+// fd_writable reads the open flags from an fdinfo file and reports whether
+// the descriptor was opened for writing.
+func fd_writable(fdinfo string) bool {
+	file, err := os.Open(fdinfo)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "flags:")
+		if !ok {
+			continue
+		}
+		flags, err := strconv.ParseUint(strings.TrimSpace(value), 8, 64)
+		if err != nil {
+			return false
+		}
+		mode := flags & syscall.O_ACCMODE
+		return mode == syscall.O_WRONLY || mode == syscall.O_RDWR
+	}
+	return false
+}
+
+// is_open reports whether path, resolved the way /proc shows it, is in open.
+func is_open(open map[string]bool, path string) bool {
+	if len(open) == 0 {
+		return false
+	}
+	real, err := filepath.EvalSymlinks(path)
+	return err == nil && open[real]
+}
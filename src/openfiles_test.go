@@ -0,0 +1,143 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hold_open keeps path open for writing in another process until the test
+// ends, as a logger or database would.
+func hold_open(t *testing.T, path string) {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", `exec 3>>"$0"; sleep 30`, path)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	for i := 0; i < 100; i++ {
+		if is_open(files_open_for_writing(), path) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s never showed up as open for writing", path)
+}
+
+func zip_names(t *testing.T, path string) []string {
+	t.Helper()
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	var names []string
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSkipOpenFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		task   func(source, store string) BackupTask
+		want   []string
+		failed bool
+	}{
+		{"plain", func(source, store string) BackupTask {
+			return BackupTask{}
+		}, []string{"site/", "site/index.html"}, false},
+		{"delta", func(source, store string) BackupTask {
+			return BackupTask{DeltaMode: true}
+		}, []string{"site/", "site/index.html"}, false},
+		{"source list", func(source, store string) BackupTask {
+			list := filepath.Join(store, "..", "list.txt")
+			os.WriteFile(list, []byte("index.html\naccess.log\n"), 0644)
+			return BackupTask{SourceListFile: list}
+		}, []string{"site/index.html"}, false},
+		{"single file", func(source, store string) BackupTask {
+			return BackupTask{BackupSource: filepath.Join(source, "access.log")}
+		}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, "site")
+			store := filepath.Join(dir, "store")
+			os.MkdirAll(source, 0755)
+			os.MkdirAll(store, 0755)
+			os.WriteFile(filepath.Join(source, "index.html"), []byte("<html>"), 0644)
+			log := filepath.Join(source, "access.log")
+			os.WriteFile(log, []byte("GET /\n"), 0644)
+			hold_open(t, log)
+
+			task := tt.task(source, store)
+			task.Website, task.StorePath, task.SkipOpenFiles = "site", store, true
+			if task.BackupSource == "" {
+				task.BackupSource = source
+			}
+			files, err := archive_source(task, filepath.Join(store, "site-20261014-100000.zip"))
+			if (err != nil) != tt.failed {
+				t.Fatalf("err = %v, want failure %v", err, tt.failed)
+			}
+			if tt.failed {
+				return
+			}
+			if got := zip_names(t, files[0]); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("archive holds %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A file left out because it was open must not be recorded as deleted, or
+// restoring the chain would remove the copy an earlier archive holds.
+func TestSkipOpenFilesDelta(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "site")
+	store := filepath.Join(dir, "store")
+	os.MkdirAll(source, 0755)
+	os.MkdirAll(store, 0755)
+	log := filepath.Join(source, "access.log")
+	os.WriteFile(log, []byte("GET /\n"), 0644)
+	task := BackupTask{Website: "site", BackupSource: source, StorePath: store, DeltaMode: true, SkipOpenFiles: true}
+	if _, err := archive_source(task, filepath.Join(store, "site-20261014-100000.zip")); err != nil {
+		t.Fatal(err)
+	}
+
+	hold_open(t, log)
+	files, err := archive_source(task, filepath.Join(store, "site-20261014-110000.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := zip.OpenReader(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	for _, file := range reader.File {
+		if file.Name != delta_deleted_name {
+			continue
+		}
+		deleted, err := read_deleted_list(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deleted) != 0 {
+			t.Errorf("delta records %v as deleted", deleted)
+		}
+	}
+	if state := load_delta_state(store); state == nil || len(state.Deltas) != 1 {
+		t.Fatalf("delta state = %+v, want one delta", state)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type sourceEntry struct {
+	path string
+	name string
+	info os.FileInfo
+}
+
+// collect_source walks source, skipping the store path, and returns the
+// entries in walk order.
+func collect_source(source, store_path string) ([]sourceEntry, error) {
+	var entries []sourceEntry
+	err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path_within(path, store_path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := filepath.Join(filepath.Base(source), path[len(source):])
+		entries = append(entries, sourceEntry{path: path, name: name, info: info})
+		return nil
+	})
+	return entries, err
+}
+
+// source_entries returns the entries of the task's source in archive order,
+// leaving out files other processes are writing when SkipOpenFiles is set
+// and directories without files below them when OmitEmptyDirs is set.
+func source_entries(task BackupTask, store_path string) ([]sourceEntry, error) {
+	entries, err := collect_source(task.BackupSource, store_path)
+	if err != nil {
+		return nil, err
+	}
+	if task.SkipOpenFiles {
+		open := files_open_for_writing()
+		kept := entries[:0]
+		for _, entry := range entries {
+			if is_open(open, entry.path) {
+				log.Printf("Skipping %s in %s: open for writing by another process", entry.path, task_name(task))
+				continue
+			}
+			kept = append(kept, entry)
+		}
+		entries = kept
+	}
+	if task.OmitEmptyDirs {
+		used := map[string]bool{}
+		for _, entry := range entries {
+			if entry.info.IsDir() {
+				continue
+			}
+			for dir := filepath.Dir(entry.path); !used[dir] && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+				used[dir] = true
+			}
+		}
+		kept := entries[:0]
+		for _, entry := range entries {
+			if !entry.info.IsDir() || used[entry.path] {
+				kept = append(kept, entry)
+			}
+		}
+		entries = kept
+	}
+	sort_source(entries, task.SortBy)
+	return entries, nil
+}
+
+// createParallelZip compresses the files of source with ArchiveWorkers
+// workers, each into its own temporary zip, then merges the already
+// compressed entries into target in archive order without deflating them
+// again. The result has the same entries in the same order as createZip.
+func createParallelZip(task BackupTask, target string) error {
+	entries, err := source_entries(task, filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+
+	workers := task.ArchiveWorkers
+	owner := make([]int, len(entries))
+	var files int
+	for i, entry := range entries {
+		if !entry.info.IsDir() {
+			owner[i] = files % workers
+			files++
+		}
+	}
+
+	bar := new_progress_bar("Archiving "+task_name(task), int64(len(entries)))
+	defer bar.finish()
+	temps := make([]string, workers)
+	lists := make([]*fileList, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		temps[w] = filepath.Join(filepath.Dir(target), fmt.Sprintf(".%s.w%d.tmp", filepath.Base(target), w))
+		lists[w] = new_file_list(task.FileList)
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			errs[w] = write_worker_zip(temps[w], entries, owner, w, lists[w], bar)
+		}(w)
+	}
+	wg.Wait()
+	defer func() {
+		for _, temp := range temps {
+			os.Remove(temp)
+		}
+	}()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	readers := make([]*zip.ReadCloser, workers)
+	for w := range temps {
+		reader, err := zip.OpenReader(temps[w])
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		readers[w] = reader
+	}
+
+	zipfile, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer zipfile.Close()
+
+	archive := zip.NewWriter(zipfile)
+	defer archive.Close()
+
+	list := new_file_list(task.FileList)
+	next := make([]int, workers)
+	for i, entry := range entries {
+		if entry.info.IsDir() {
+			if err := add_zip_entry_named(archive, entry.name, entry.path, entry.info, nil); err != nil {
+				return err
+			}
+			bar.add(1)
+			continue
+		}
+		w := owner[i]
+		if err := archive.Copy(readers[w].File[next[w]]); err != nil {
+			return err
+		}
+		if list != nil {
+			list.lines = append(list.lines, lists[w].lines[next[w]])
+		}
+		next[w]++
+	}
+	return finish_zip(archive, task, target, list)
+}
+
+// write_worker_zip compresses the files owned by worker w into target.
+func write_worker_zip(target string, entries []sourceEntry, owner []int, w int, list *fileList, bar *progressBar) error {
+	zipfile, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer zipfile.Close()
+
+	archive := zip.NewWriter(zipfile)
+	for i, entry := range entries {
+		if entry.info.IsDir() || owner[i] != w {
+			continue
+		}
+		if err := add_zip_entry_named(archive, entry.name, entry.path, entry.info, list); err != nil {
+			return err
+		}
+		bar.add(1)
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return zipfile.Close()
+}
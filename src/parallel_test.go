@@ -0,0 +1,155 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestOmitEmptyDirs(t *testing.T) {
+	tests := []struct {
+		name string
+		task BackupTask
+	}{
+		{"plain", BackupTask{}},
+		{"split", BackupTask{SplitBySize: 1 << 20}},
+		{"parallel", BackupTask{ArchiveWorkers: 2}},
+		{"delta", BackupTask{DeltaMode: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, "site")
+			store := filepath.Join(dir, "store")
+			os.MkdirAll(filepath.Join(source, "cache", "empty"), 0755)
+			os.MkdirAll(filepath.Join(source, "css"), 0755)
+			os.MkdirAll(store, 0755)
+			os.WriteFile(filepath.Join(source, "css", "site.css"), []byte("body {}"), 0644)
+
+			task := tt.task
+			task.Website, task.BackupSource, task.StorePath, task.OmitEmptyDirs = "site", source, store, true
+			files, err := archive_source(task, filepath.Join(store, "site-20261014-100000.zip"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "site/,site/css/,site/css/site.css"
+			if got := strings.Join(zip_names(t, files[0]), ","); got != want {
+				t.Errorf("archive holds %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+// zip_contents lists the entries of the zip at path in order, with their
+// contents.
+func zip_contents(t *testing.T, path string) []string {
+	t.Helper()
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	var entries []string
+	for _, file := range reader.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", file.Name, err)
+		}
+		entries = append(entries, file.Name+"="+string(data))
+	}
+	return entries
+}
+
+func TestParallelZip(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "site")
+	for i := 0; i < 7; i++ {
+		path := filepath.Join(source, fmt.Sprintf("dir%d", i%3), fmt.Sprintf("file%d.html", i))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(strings.Repeat(fmt.Sprintf("page %d ", i), 100*i)), 0644)
+	}
+	serial := filepath.Join(dir, "serial", "site-20261014-100000.zip")
+	os.MkdirAll(filepath.Dir(serial), 0755)
+	if err := createZip(BackupTask{Website: "site", BackupSource: source, StorePath: filepath.Dir(serial), FileList: true}, serial); err != nil {
+		t.Fatal(err)
+	}
+	want := zip_contents(t, serial)
+	want_list, _ := os.ReadFile(serial + file_list_suffix)
+
+	for _, workers := range []int{2, 3, 16} {
+		t.Run(fmt.Sprint(workers), func(t *testing.T) {
+			store := filepath.Join(dir, fmt.Sprint("store", workers))
+			os.MkdirAll(store, 0755)
+			task := BackupTask{Website: "site", BackupSource: source, StorePath: store, ArchiveWorkers: workers, FileList: true}
+			target := filepath.Join(store, "site-20261014-100000.zip")
+			if err := createParallelZip(task, target); err != nil {
+				t.Fatal(err)
+			}
+			if got := zip_contents(t, target); strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("parallel archive differs from the serial one:\n%v\nwant\n%v", got, want)
+			}
+			if list, _ := os.ReadFile(target + file_list_suffix); string(list) != string(want_list) {
+				t.Errorf("file list = %q, want %q", list, want_list)
+			}
+			if got := remaining(store); len(got) != 2 {
+				t.Errorf("StorePath holds %v, want the archive and its file list", got)
+			}
+		})
+	}
+}
+
+// write_benchmark_tree writes a source of 200 files of mixed random and
+// repetitive content for the archive benchmarks.
+func write_benchmark_tree(b *testing.B, source string) {
+	b.Helper()
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		data := make([]byte, 64<<10)
+		random.Read(data[:16<<10])
+		copy(data[16<<10:], strings.Repeat(fmt.Sprintf("<p>page %d</p>\n", i), 4<<10))
+		path := filepath.Join(source, fmt.Sprintf("dir%d", i%8), fmt.Sprintf("page%d.html", i))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCreateZip(b *testing.B) {
+	dir := b.TempDir()
+	source := filepath.Join(dir, "site")
+	store := filepath.Join(dir, "store")
+	write_benchmark_tree(b, source)
+	os.MkdirAll(store, 0755)
+	task := BackupTask{Website: "site", BackupSource: source, StorePath: store}
+	for i := 0; i < b.N; i++ {
+		if err := createZip(task, filepath.Join(store, "site-20261014-100000.zip")); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCreateParallelZip(b *testing.B) {
+	dir := b.TempDir()
+	source := filepath.Join(dir, "site")
+	store := filepath.Join(dir, "store")
+	write_benchmark_tree(b, source)
+	os.MkdirAll(store, 0755)
+	task := BackupTask{Website: "site", BackupSource: source, StorePath: store, ArchiveWorkers: runtime.GOMAXPROCS(0)}
+	for i := 0; i < b.N; i++ {
+		if err := createParallelZip(task, filepath.Join(store, "site-20261014-100000.zip")); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
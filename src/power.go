@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// power_supply_dir is where Linux lists the host's power supplies.
+var power_supply_dir = "/sys/class/power_supply"
+
+func read_supply(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// on_battery reports whether the host runs on battery: it has a battery
+// that is discharging and no mains or USB supply online. Hosts without
+// batteries, and systems without power_supply_dir, are never on battery.
+func on_battery() bool {
+	supplies, err := os.ReadDir(power_supply_dir)
+	if err != nil {
+		return false
+	}
+	discharging := false
+	for _, supply := range supplies {
+		dir := filepath.Join(power_supply_dir, supply.Name())
+		switch read_supply(dir, "type") {
+		case "Mains", "USB":
+			if read_supply(dir, "online") == "1" {
+				return false
+			}
+		case "Battery":
+			// Peripherals such as a wireless mouse report a battery of
+			// their own; only the host's batteries power the machine.
+			if read_supply(dir, "scope") == "Device" {
+				continue
+			}
+			if read_supply(dir, "status") == "Discharging" {
+				discharging = true
+			}
+		}
+	}
+	return discharging
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestOnBattery(t *testing.T) {
+	ac := func(online string) map[string]string { return map[string]string{"type": "Mains", "online": online} }
+	battery := func(status string) map[string]string { return map[string]string{"type": "Battery", "status": status} }
+	mouse := map[string]string{"type": "Battery", "status": "Discharging", "scope": "Device"}
+	tests := []struct {
+		name     string
+		supplies map[string]map[string]string
+		want     bool
+	}{
+		{"desktop", map[string]map[string]string{}, false},
+		{"on mains", map[string]map[string]string{"AC": ac("1"), "BAT0": battery("Charging")}, false},
+		{"on battery", map[string]map[string]string{"AC": ac("0"), "BAT0": battery("Discharging")}, true},
+		{"battery full on mains", map[string]map[string]string{"AC": ac("1"), "BAT0": battery("Full")}, false},
+		{"reports discharging on mains", map[string]map[string]string{"AC": ac("1"), "BAT0": battery("Discharging")}, false},
+		{"powered by USB-C", map[string]map[string]string{"ucsi-source-psy-USBC000:001": {"type": "USB", "online": "1"}, "BAT0": battery("Discharging")}, false},
+		{"second battery", map[string]map[string]string{"AC": ac("0"), "BAT0": battery("Unknown"), "BAT1": battery("Discharging")}, true},
+		{"wireless mouse on a desktop", map[string]map[string]string{"hidpp_battery_0": mouse}, false},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		for name, files := range tt.supplies {
+			write_tree(t, filepath.Join(dir, name), files)
+		}
+		saved := power_supply_dir
+		power_supply_dir = dir
+		if got := on_battery(); got != tt.want {
+			t.Errorf("%s: on_battery = %v, want %v", tt.name, got, tt.want)
+		}
+		power_supply_dir = saved
+	}
+
+	saved := power_supply_dir
+	power_supply_dir = filepath.Join(t.TempDir(), "missing")
+	defer func() { power_supply_dir = saved }()
+	if on_battery() {
+		t.Error("on_battery without power_supply_dir")
+	}
+}
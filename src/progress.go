@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// notify_progress sends a "still running" notification each time the task
+// has run for another ProgressNotifyAfter, so a slow backup can be told
+// apart from a hung one. The returned func stops the notifications.
+func notify_progress(task BackupTask, botToken string, chatID int64, enable bool) func() {
+	interval, err := time.ParseDuration(task.ProgressNotifyAfter)
+	if err != nil || interval <= 0 {
+		return func() {}
+	}
+	start := time.Now()
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				elapsed := time.Since(start).Round(time.Second)
+				send_message(botToken, chatID, fmt.Sprintf("Backup '%s' still running after %s", task_name(task), elapsed), enable)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
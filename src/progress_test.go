@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifyProgress(t *testing.T) {
+	tests := []struct {
+		after string
+		run   time.Duration
+		min   int // notifications expected at least
+		max   int
+	}{
+		{"", 120 * time.Millisecond, 0, 0},
+		{"0s", 120 * time.Millisecond, 0, 0},
+		{"later", 120 * time.Millisecond, 0, 0},
+		{"50ms", 130 * time.Millisecond, 1, 2},
+		{"1h", 50 * time.Millisecond, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.after, func(t *testing.T) {
+			bot := record_messages(t)
+			stop := notify_progress(BackupTask{Website: "site", ProgressNotifyAfter: tt.after}, "token", 1, true)
+			time.Sleep(tt.run)
+			stop()
+			sent := len(bot.messages())
+			time.Sleep(80 * time.Millisecond)
+			if after := len(bot.messages()); after != sent {
+				t.Errorf("%d notifications sent after stop", after-sent)
+			}
+			if sent < tt.min || sent > tt.max {
+				t.Errorf("sent %d notifications, want %d to %d: %v", sent, tt.min, tt.max, bot.messages())
+			}
+			for _, message := range bot.messages() {
+				if !strings.HasPrefix(message, "Backup 'site' still running after ") {
+					t.Errorf("unexpected notification %q", message)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	default_queue_max_messages = 100
+	default_queue_max_age      = 24 * time.Hour
+)
+
+type queuedMessage struct {
+	Time    time.Time `json:"Time"`
+	ChatID  int64     `json:"ChatID"`
+	Message string    `json:"Message"`
+}
+
+// messageQueue keeps the Telegram messages that could not be sent in a
+// JSON file, to be retried on the next run.
+type messageQueue struct {
+	mu           sync.Mutex
+	path         string
+	max_messages int
+	max_age      time.Duration
+}
+
+// telegram_queue is set from Telegram.QueueFile. Without it, messages that
+// cannot be sent are dropped.
+var telegram_queue *messageQueue
+
+// new_message_queue builds the queue configured in the telegram block, or
+// returns nil when QueueFile is unset.
+func new_message_queue(telegram Telegram) (*messageQueue, error) {
+	if telegram.QueueFile == "" {
+		return nil, nil
+	}
+	queue := &messageQueue{
+		path:         telegram.QueueFile,
+		max_messages: telegram.QueueMaxMessages,
+		max_age:      default_queue_max_age,
+	}
+	if queue.max_messages <= 0 {
+		queue.max_messages = default_queue_max_messages
+	}
+	if telegram.QueueMaxAge != "" {
+		age, err := time.ParseDuration(telegram.QueueMaxAge)
+		if err != nil || age <= 0 {
+			return nil, fmt.Errorf("invalid QueueMaxAge %q", telegram.QueueMaxAge)
+		}
+		queue.max_age = age
+	}
+	return queue, nil
+}
+
+func (q *messageQueue) load() ([]queuedMessage, error) {
+	data, err := os.ReadFile(q.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var messages []queuedMessage
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, fmt.Errorf("%s: %v", q.path, err)
+	}
+	return messages, nil
+}
+
+// save replaces the queue file, or removes it once the queue is empty.
+func (q *messageQueue) save(messages []queuedMessage) error {
+	if len(messages) == 0 {
+		if err := os.Remove(q.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(messages, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(q.path), "."+filepath.Base(q.path)+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
+
+// expire drops the messages older than max_age and, past max_messages,
+// the oldest ones.
+func (q *messageQueue) expire(messages []queuedMessage, now time.Time) []queuedMessage {
+	kept := messages[:0]
+	for _, m := range messages {
+		if now.Sub(m.Time) <= q.max_age {
+			kept = append(kept, m)
+		}
+	}
+	if dropped := len(messages) - len(kept); dropped > 0 {
+		log.Printf("Dropping %d queued Telegram message(s) older than %s", dropped, q.max_age)
+	}
+	if len(kept) > q.max_messages {
+		log.Printf("Dropping %d queued Telegram message(s) over the limit of %d", len(kept)-q.max_messages, q.max_messages)
+		kept = kept[len(kept)-q.max_messages:]
+	}
+	return kept
+}
+
+// add queues a message that could not be sent. A nil queue drops it.
+func (q *messageQueue) add(chatID int64, message string) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	messages, err := q.load()
+	if err != nil {
+		log.Printf("Error reading Telegram queue: %v", err)
+	}
+	messages = append(messages, queuedMessage{Time: time.Now(), ChatID: chatID, Message: message})
+	if err := q.save(q.expire(messages, time.Now())); err != nil {
+		log.Printf("Error queueing Telegram message: %v", err)
+		return
+	}
+	log.Printf("Queued Telegram message for the next run: %s", message)
+}
+
+// flush sends the queued messages in order, each noting when it was first
+// attempted. It stops at the first failure and keeps the rest queued.
+func (q *messageQueue) flush(botToken string) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	messages, err := q.load()
+	if err != nil {
+		log.Printf("Error reading Telegram queue: %v", err)
+		return
+	}
+	messages = q.expire(messages, time.Now())
+	if len(messages) == 0 {
+		if err := q.save(nil); err != nil {
+			log.Printf("Error clearing Telegram queue: %v", err)
+		}
+		return
+	}
+
+	sent := 0
+	if bot, err := new_telegram_bot(botToken); err != nil {
+		log.Printf("Error creating Telegram bot, keeping %d queued message(s): %v", len(messages), err)
+	} else {
+		for _, m := range messages {
+			text := m.Message + "\n(queued " + m.Time.Format("2006-01-02 15:04:05 MST") + ")"
+			if _, err := bot.Send(tgbotapi.NewMessage(m.ChatID, text)); err != nil {
+				log.Printf("Error sending queued message, keeping %d queued: %v", len(messages)-sent, err)
+				break
+			}
+			sent++
+		}
+	}
+	if sent > 0 {
+		log.Printf("Sent %d queued Telegram message(s)", sent)
+	}
+	if err := q.save(messages[sent:]); err != nil {
+		log.Printf("Error updating Telegram queue: %v", err)
+	}
+}
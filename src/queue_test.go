@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// flakyBot delivers its first deliver messages, then fails every send.
+type flakyBot struct {
+	mu      sync.Mutex
+	deliver int
+	sent    []string
+}
+
+func (b *flakyBot) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.sent) == b.deliver {
+		return tgbotapi.Message{}, errors.New("network is unreachable")
+	}
+	b.sent = append(b.sent, c.(tgbotapi.MessageConfig).Text)
+	return tgbotapi.Message{}, nil
+}
+
+// use_bot makes the test's Telegram messages go to bot.
+func use_bot(t *testing.T, bot telegramBot) {
+	t.Helper()
+	saved := new_telegram_bot
+	new_telegram_bot = func(string) (telegramBot, error) { return bot, nil }
+	t.Cleanup(func() { new_telegram_bot = saved })
+}
+
+func TestNewMessageQueue(t *testing.T) {
+	tests := []struct {
+		telegram Telegram
+		none     bool
+		max      int
+		age      time.Duration
+		fails    bool
+	}{
+		{Telegram{}, true, 0, 0, false},
+		{Telegram{QueueFile: "/var/lib/goback/queue.json"}, false, default_queue_max_messages, default_queue_max_age, false},
+		{Telegram{QueueFile: "q.json", QueueMaxMessages: 5, QueueMaxAge: "2h"}, false, 5, 2 * time.Hour, false},
+		{Telegram{QueueFile: "q.json", QueueMaxAge: "a day"}, true, 0, 0, true},
+		{Telegram{QueueFile: "q.json", QueueMaxAge: "-1h"}, true, 0, 0, true},
+	}
+	for _, tt := range tests {
+		queue, err := new_message_queue(tt.telegram)
+		if (err != nil) != tt.fails || (queue == nil) != tt.none {
+			t.Errorf("new_message_queue(%+v) = %v, %v", tt.telegram, queue, err)
+			continue
+		}
+		if queue != nil && (queue.max_messages != tt.max || queue.max_age != tt.age) {
+			t.Errorf("new_message_queue(%+v) keeps %d for %v, want %d for %v", tt.telegram, queue.max_messages, queue.max_age, tt.max, tt.age)
+		}
+	}
+}
+
+func TestMessageQueueExpire(t *testing.T) {
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	var messages []queuedMessage
+	for _, age := range []time.Duration{30 * time.Hour, 3 * time.Hour, 2 * time.Hour, time.Hour} {
+		messages = append(messages, queuedMessage{Time: now.Add(-age), Message: age.String()})
+	}
+	tests := []struct {
+		max_messages int
+		max_age      time.Duration
+		want         string
+	}{
+		{100, 24 * time.Hour, "3h0m0s,2h0m0s,1h0m0s"},
+		{2, 24 * time.Hour, "2h0m0s,1h0m0s"},
+		{100, 48 * time.Hour, "30h0m0s,3h0m0s,2h0m0s,1h0m0s"},
+		{100, 90 * time.Minute, "1h0m0s"},
+	}
+	for _, tt := range tests {
+		queue := &messageQueue{max_messages: tt.max_messages, max_age: tt.max_age}
+		var got []string
+		for _, m := range queue.expire(append([]queuedMessage(nil), messages...), now) {
+			got = append(got, m.Message)
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("expire(%d, %v) kept %v, want %s", tt.max_messages, tt.max_age, got, tt.want)
+		}
+	}
+}
+
+func TestMessageQueueFlush(t *testing.T) {
+	tests := []struct {
+		deliver int
+		left    int
+	}{
+		{3, 0},
+		{1, 2},
+		{0, 3},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		queue := &messageQueue{path: filepath.Join(dir, "queue.json"), max_messages: 100, max_age: time.Hour}
+		saved := telegram_queue
+		telegram_queue = queue
+
+		// Sending fails, so send_message queues.
+		use_bot(t, &flakyBot{})
+		for _, message := range []string{"Backup FAILED: a", "Backup FAILED: b", "Backup FAILED: c"} {
+			send_message("token", 1, message, true)
+		}
+		bot := &flakyBot{deliver: tt.deliver}
+		use_bot(t, bot)
+		queue.flush("token")
+		telegram_queue = saved
+
+		if len(bot.sent) != tt.deliver {
+			t.Fatalf("deliver %d: sent %q", tt.deliver, bot.sent)
+		}
+		for i, text := range bot.sent {
+			if !strings.HasPrefix(text, "Backup FAILED: "+string(rune('a'+i))+"\n(queued ") {
+				t.Errorf("deliver %d: sent %q out of order or without its queue time", tt.deliver, text)
+			}
+		}
+		left, err := queue.load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(left) != tt.left || (tt.left > 0 && left[0].Message != "Backup FAILED: "+string(rune('a'+tt.deliver))) {
+			t.Errorf("deliver %d: left %v queued, want the last %d", tt.deliver, left, tt.left)
+		}
+		if _, err := os.Stat(queue.path); (err == nil) != (tt.left > 0) {
+			t.Errorf("deliver %d: queue file exists %v with %d left", tt.deliver, err == nil, tt.left)
+		}
+	}
+}
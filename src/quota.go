@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+const default_remote_quota_percent = 90.0
+
+// remoteAbout is the part of `rclone about --json` the quota check reads.
+// Backends report only the fields they know; a missing total means the
+// remote has no quota to run out of.
+type remoteAbout struct {
+	Total *int64 `json:"total"`
+	Used  *int64 `json:"used"`
+	Free  *int64 `json:"free"`
+}
+
+// quota_used returns how much of the quota is taken and the quota itself.
+// Free is preferred over used, as trash and other files count against the
+// quota too.
+func quota_used(about remoteAbout) (int64, int64, bool) {
+	if about.Total == nil || *about.Total <= 0 {
+		return 0, 0, false
+	}
+	switch {
+	case about.Free != nil:
+		return *about.Total - *about.Free, *about.Total, true
+	case about.Used != nil:
+		return *about.Used, *about.Total, true
+	}
+	return 0, 0, false
+}
+
+// check_remote_quota alerts when the task's remote is fuller than
+// RemoteQuotaPercent, before uploads start failing on a full remote.
+// Remotes rclone cannot report a quota for, e.g. S3, are only logged.
+func check_remote_quota(task BackupTask, botToken string, chatID int64, enable bool) {
+	percent := task.RemoteQuotaPercent
+	if percent <= 0 {
+		percent = default_remote_quota_percent
+	}
+	output, err := run_command(binary(task.RclonePath, "rclone"), "about", "--json", task.RemotePath).Output()
+	if err != nil {
+		log.Printf("Error reading the quota of %s: %v", task.RemotePath, err)
+		return
+	}
+	var about remoteAbout
+	if err := json.Unmarshal(output, &about); err != nil {
+		log.Printf("Error reading the quota of %s: %v", task.RemotePath, err)
+		return
+	}
+	used, total, ok := quota_used(about)
+	if !ok {
+		log.Printf("%s reports no quota, not checking it", task.RemotePath)
+		return
+	}
+	full := float64(used) * 100 / float64(total)
+	if full < percent {
+		return
+	}
+	remote := strings.SplitN(task.RemotePath, ":", 2)[0] + ":"
+	message := fmt.Sprintf("Remote Quota: %s is %.0f%% full, %s of %s used", remote, full, format_bytes(uint64(used)), format_bytes(uint64(total)))
+	log.Print(message)
+	send_message(botToken, chatID, message, enable)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQuotaUsed(t *testing.T) {
+	n := func(v int64) *int64 { return &v }
+	tests := []struct {
+		name  string
+		about remoteAbout
+		used  int64
+		total int64
+		ok    bool
+	}{
+		{"free preferred", remoteAbout{Total: n(100), Used: n(50), Free: n(20)}, 80, 100, true},
+		{"used only", remoteAbout{Total: n(100), Used: n(50)}, 50, 100, true},
+		{"no total", remoteAbout{Used: n(50), Free: n(20)}, 0, 0, false},
+		{"zero total", remoteAbout{Total: n(0), Free: n(0)}, 0, 0, false},
+		{"total only", remoteAbout{Total: n(100)}, 0, 0, false},
+	}
+	for _, tt := range tests {
+		used, total, ok := quota_used(tt.about)
+		if used != tt.used || total != tt.total || ok != tt.ok {
+			t.Errorf("%s: quota_used = %d, %d, %v, want %d, %d, %v", tt.name, used, total, ok, tt.used, tt.total, tt.ok)
+		}
+	}
+}
+
+func TestCheckRemoteQuota(t *testing.T) {
+	tests := []struct {
+		name    string
+		about   string // what rclone about --json prints
+		percent float64
+		message string
+	}{
+		{"below", `{"total": 1073741824, "used": 536870912, "free": 536870912}`, 0, ""},
+		{"over the default", `{"total": 1073741824, "free": 53687091}`, 0, "Remote Quota: onedrive: is 95% full, 972.8 MiB of 1.0 GiB used"},
+		{"over RemoteQuotaPercent", `{"total": 1073741824, "used": 858993459}`, 75, "Remote Quota: onedrive: is 80% full, 819.2 MiB of 1.0 GiB used"},
+		{"under RemoteQuotaPercent", `{"total": 1073741824, "free": 53687091}`, 99, ""},
+		{"no quota", `{"used": 858993459}`, 0, ""},
+		{"unreadable", `not json`, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := record_messages(t)
+			dir := t.TempDir()
+			rclone := filepath.Join(dir, "rclone")
+			os.WriteFile(rclone, []byte("#!/bin/sh\necho \"$@\" > "+filepath.Join(dir, "args")+"\necho '"+tt.about+"'\n"), 0755)
+			task := BackupTask{Website: "site", RemotePath: "onedrive:backup/site", RclonePath: rclone, RemoteQuotaPercent: tt.percent}
+			check_remote_quota(task, "token", 1, true)
+			if got := strings.Join(bot.messages(), ","); got != tt.message {
+				t.Errorf("sent %q, want %q", got, tt.message)
+			}
+			if args, _ := os.ReadFile(filepath.Join(dir, "args")); string(args) != "about --json onedrive:backup/site\n" {
+				t.Errorf("rclone ran %q", args)
+			}
+		})
+	}
+}
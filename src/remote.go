@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// list_remote returns the names of the files directly under the task's
+// remote.
+func list_remote(task BackupTask) ([]string, error) {
+	output, err := run_command(binary(task.RclonePath, "rclone"), "lsf", "--files-only", task.RemotePath).Output()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// local_backup_files returns the names of the task's backups in StorePath.
+func local_backup_files(task BackupTask) []string {
+	var names []string
+	pattern := task_file_pattern(task)
+	files, _ := os.ReadDir(task.StorePath)
+	for _, file := range files {
+		if !file.IsDir() && pattern.MatchString(file.Name()) && is_backup_file(file.Name()) {
+			names = append(names, file.Name())
+		}
+	}
+	return names
+}
+
+// missing_remote_backups returns the local backups absent from the remote.
+func missing_remote_backups(task BackupTask) ([]string, error) {
+	remote, err := list_remote(task)
+	if err != nil {
+		return nil, err
+	}
+	present := map[string]bool{}
+	for _, name := range remote {
+		present[name] = true
+	}
+	var missing []string
+	for _, name := range local_backup_files(task) {
+		if !present[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// verify_remote_count checks that every backup kept locally is also on the
+// remote, catching e.g. a misconfigured sync that deleted them.
+func verify_remote_count(task BackupTask, botToken string, chatID int64, enable bool) error {
+	missing, err := missing_remote_backups(task)
+	if err != nil {
+		send_message(botToken, chatID, "Verify remote backups FAILED: "+task.RemotePath, enable)
+		return err
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	expected := len(local_backup_files(task))
+	send_message(botToken, chatID, fmt.Sprintf("Remote %s is missing %d of %d expected backups: %s", task.RemotePath, len(missing), expected, strings.Join(missing, ", ")), enable)
+	return fmt.Errorf("%d backups missing on %s", len(missing), task.RemotePath)
+}
+
+// repair_remote uploads the named task's local backups, and their sidecars,
+// that are missing from its remote. Nothing on the remote is deleted or
+// overwritten.
+func repair_remote(config Config, name string) error {
+	var task BackupTask
+	found := false
+	for _, t := range all_tasks(config) {
+		if task_name(t) == name {
+			task, found = t, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("no task named %q in the config", name)
+	}
+
+	missing, err := missing_remote_backups(task)
+	if err != nil {
+		return fmt.Errorf("listing %s: %v", task.RemotePath, err)
+	}
+	if len(missing) == 0 {
+		fmt.Println("Remote", task.RemotePath, "already holds every local backup of", name)
+		return nil
+	}
+	for _, backup := range missing {
+		files := []string{backup}
+		for _, suffix := range backup_sidecar_suffixes {
+			if _, err := os.Stat(filepath.Join(task.StorePath, backup+suffix)); err == nil {
+				files = append(files, backup+suffix)
+			}
+		}
+		for _, file := range files {
+			args := []string{"copyto", filepath.Join(task.StorePath, file), task.RemotePath + "/" + file}
+			if task.RemoteRetentionTag != "" {
+				args = append(args, "--header-upload", "X-Amz-Tagging: "+task.RemoteRetentionTag)
+			}
+			if output, err := run_command(binary(task.RclonePath, "rclone"), args...).CombinedOutput(); err != nil {
+				return fmt.Errorf("uploading %s: %v: %s", file, err, strings.TrimSpace(string(output)))
+			}
+			fmt.Println("Uploaded", file)
+		}
+	}
+	return nil
+}
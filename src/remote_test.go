@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyRemoteCount(t *testing.T) {
+	local := []string{"site-20261013-100000.zip", "site-20261014-100000.zip", "site-20261014-100000.zip" + file_list_suffix}
+	tests := []struct {
+		name    string
+		remote  []string
+		message string
+		fails   bool
+	}{
+		{"remote complete", local, "", false},
+		{"remote holds more", append([]string{"site-20261012-100000.zip"}, local...), "", false},
+		{"remote missing one", local[1:], "Remote r:x/site is missing 1 of 2 expected backups: site-20261013-100000.zip", true},
+		{"sidecars do not count", local[:2], "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store := filepath.Join(dir, "store")
+			os.MkdirAll(filepath.Join(dir, "remote"), 0755)
+			os.MkdirAll(store, 0755)
+			for _, name := range local {
+				os.WriteFile(filepath.Join(store, name), []byte(name), 0644)
+			}
+			os.WriteFile(filepath.Join(store, "site-staging-20261010-100000.zip"), nil, 0644)
+			for _, name := range tt.remote {
+				os.WriteFile(filepath.Join(dir, "remote", name), []byte(name), 0644)
+			}
+			bot := record_messages(t)
+			task := BackupTask{Website: "site", StorePath: store, RemotePath: "r:x/site", RclonePath: fake_rclone(t, dir)}
+			err := verify_remote_count(task, "token", 1, true)
+			if (err != nil) != tt.fails {
+				t.Fatalf("err = %v, want failure %v", err, tt.fails)
+			}
+			if got := strings.Join(bot.messages(), "\n"); got != tt.message {
+				t.Errorf("sent %q, want %q", got, tt.message)
+			}
+		})
+	}
+}
+
+func TestRepairRemote(t *testing.T) {
+	tests := []struct {
+		name   string
+		remote map[string]string
+		want   []string // the remote's files after the repair
+	}{
+		{"remote complete",
+			map[string]string{"site-20261013-100000.zip": "remote", "site-20261014-100000.zip": "remote"},
+			[]string{"site-20261013-100000.zip=remote", "site-20261014-100000.zip=remote"}},
+		{"remote missing one",
+			map[string]string{"site-20261014-100000.zip": "remote"},
+			[]string{"site-20261013-100000.zip" + file_list_suffix + "=list", "site-20261013-100000.zip=local", "site-20261014-100000.zip=remote"}},
+		{"remote empty", nil,
+			[]string{"site-20261013-100000.zip" + file_list_suffix + "=list", "site-20261013-100000.zip=local", "site-20261014-100000.zip=local"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store := filepath.Join(dir, "store")
+			remote := filepath.Join(dir, "remote")
+			write_tree(t, store, map[string]string{
+				"site-20261013-100000.zip":                    "local",
+				"site-20261013-100000.zip" + file_list_suffix: "list",
+				"site-20261014-100000.zip":                    "local",
+				"blog-20261014-100000.zip":                    "other task",
+			})
+			os.MkdirAll(remote, 0755)
+			write_tree(t, remote, tt.remote)
+			config := Config{WebsiteTasks: []BackupTask{
+				{Website: "site", StorePath: store, RemotePath: "r:x/site", RclonePath: fake_rclone(t, dir)},
+			}}
+			if err := repair_remote(config, "site"); err != nil {
+				t.Fatal(err)
+			}
+			if got := tree_contents(t, remote); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("remote holds %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if err := repair_remote(Config{}, "site"); err == nil {
+		t.Error("repair_remote accepted an unknown task")
+	}
+}
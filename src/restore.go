@@ -0,0 +1,248 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var backup_name_pattern = regexp.MustCompile(`^(.*)-\d{8}-\d{6}(\.delta)?(\.[^.]+)$`)
+
+// is_delta_name reports whether name is a delta of a DeltaMode chain.
+func is_delta_name(name string) bool {
+	match := backup_name_pattern.FindStringSubmatch(name)
+	return match != nil && match[2] != ""
+}
+
+// restore_chain returns the archives to extract, in order, to restore
+// archive: the archive itself, or for a delta, its baseline followed by
+// every delta up to and including it.
+func restore_chain(archive string) ([]string, error) {
+	name := filepath.Base(archive)
+	if !is_delta_name(name) {
+		return []string{archive}, nil
+	}
+	match := backup_name_pattern.FindStringSubmatch(name)
+
+	dir := filepath.Dir(archive)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []string
+	for _, entry := range entries {
+		m := backup_name_pattern.FindStringSubmatch(entry.Name())
+		if m != nil && m[1] == match[1] && m[3] == match[3] && entry.Name() <= name {
+			candidates = append(candidates, entry.Name())
+		}
+	}
+	sort.Strings(candidates)
+
+	// Walk back from archive to the most recent baseline.
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if is_delta_name(candidates[i]) {
+			continue
+		}
+		var chain []string
+		for _, c := range candidates[i:] {
+			chain = append(chain, filepath.Join(dir, c))
+		}
+		return chain, nil
+	}
+	return nil, fmt.Errorf("no baseline found for %s in %s", name, dir)
+}
+
+// safe_join resolves an archive entry name under dest and rejects names
+// that would escape it.
+func safe_join(dest, name string) (string, error) {
+	target := filepath.Join(dest, name)
+	if !path_within(target, dest) {
+		return "", fmt.Errorf("archive entry %q escapes the destination", name)
+	}
+	return target, nil
+}
+
+// restore_match reports whether the entry name, or a directory above it,
+// matches the -path glob. An empty pattern matches everything.
+func restore_match(pattern, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	for p := strings.TrimSuffix(name, "/"); p != "." && p != "/" && p != ""; p = path.Dir(p) {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// extract_zip extracts the entries of archive matching pattern into dest and
+// then removes the matching paths listed by a delta's deletion list. It
+// returns how many entries it extracted.
+func extract_zip(archive, dest, pattern string) (int, error) {
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	var deleted []string
+	extracted := 0
+	for _, file := range reader.File {
+		if file.Name == delta_deleted_name && is_delta_name(filepath.Base(archive)) {
+			deleted, err = read_deleted_list(file)
+			if err != nil {
+				return extracted, err
+			}
+			continue
+		}
+		if file.Name == restore_script_name || file.Name == drift_report_name || !restore_match(pattern, file.Name) {
+			continue
+		}
+		if err := extract_zip_file(file, dest); err != nil {
+			return extracted, err
+		}
+		extracted++
+	}
+
+	// Remove children before their parent directories.
+	sort.Sort(sort.Reverse(sort.StringSlice(deleted)))
+	for _, name := range deleted {
+		if !restore_match(pattern, name) {
+			continue
+		}
+		target, err := safe_join(dest, name)
+		if err != nil {
+			return extracted, err
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return extracted, err
+		}
+	}
+	return extracted, nil
+}
+
+func read_deleted_list(file *zip.File) ([]string, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(rc)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			names = append(names, line)
+		}
+	}
+	return names, scanner.Err()
+}
+
+func extract_zip_file(file *zip.File, dest string) error {
+	target, err := safe_join(dest, file.Name)
+	if err != nil {
+		return err
+	}
+	if file.FileInfo().IsDir() {
+		return os.MkdirAll(target, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(target, file.Modified, file.Modified)
+}
+
+// restore_backup restores archive into dest, replaying its delta chain
+// when it is a delta. With a pattern only the entries matching it, and
+// everything below matching directories, are restored.
+func restore_backup(archive, dest, pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid -path %q: %v", pattern, err)
+	}
+	chain, err := restore_chain(archive)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	extracted := 0
+	for _, name := range chain {
+		extract := extract_zip
+		if is_solid_name(name) {
+			extract = extract_solid
+		}
+		n, err := extract(name, dest, pattern)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filepath.Base(name), err)
+		}
+		extracted += n
+	}
+	if extracted == 0 && pattern != "" {
+		return fmt.Errorf("no entries match %q", pattern)
+	}
+	return nil
+}
+
+// restore_task restores archive into dest like restore_backup, then runs the
+// named task's PostRestoreCheck in dest. The restore only counts as
+// successful when the check exits zero. A failed restore is left in place
+// for inspection.
+func restore_task(config Config, name, archive, dest, pattern string) error {
+	var task BackupTask
+	found := false
+	for _, t := range all_tasks(config) {
+		if task_name(t) == name {
+			task, found = t, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("no task named %q in the config", name)
+	}
+
+	if err := restore_backup(archive, dest, pattern); err != nil {
+		return err
+	}
+	if task.PostRestoreCheck == "" {
+		return nil
+	}
+
+	cmd := run_command("sh", "-c", task.PostRestoreCheck)
+	cmd.Dir = dest
+	cmd.Env = append(os.Environ(), "GOBACK_RESTORE_ARCHIVE="+archive, "GOBACK_RESTORE_DEST="+dest)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		send_message(config.Telegram.BotToken, config.Telegram.ChatID, "Restore Check FAILED: "+name, config.Telegram.Enable)
+		return fmt.Errorf("post-restore check failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	log.Printf("Post-restore check for %s passed", name)
+	return nil
+}
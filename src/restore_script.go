@@ -0,0 +1,94 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const restore_script_name = "restore.sh"
+
+// restore_script_suffix names the restore script written next to a
+// database dump, which is not an archive the script could live in.
+const restore_script_suffix = ".restore.sh"
+
+// shell_quote quotes value for use as a single sh word.
+func shell_quote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func archive_restore_script(task BackupTask, archive string) string {
+	name := task_name(task)
+	var b strings.Builder
+	fmt.Fprintf(&b, "#!/bin/sh\n")
+	fmt.Fprintf(&b, "# Restore script for goBack backup %s\n", archive)
+	fmt.Fprintf(&b, "# Task:    %s\n", name)
+	fmt.Fprintf(&b, "# Source:  %s\n", task.BackupSource)
+	fmt.Fprintf(&b, "# Created: %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&b, "#\n")
+	fmt.Fprintf(&b, "# Usage: sh %s [archive] [destination]\n", restore_script_name)
+	fmt.Fprintf(&b, "# The archive holds %s/, so extracting into the parent of\n", filepath.Base(task.BackupSource))
+	fmt.Fprintf(&b, "# the source directory restores it in place.\n")
+	if task.SplitBySize > 0 {
+		fmt.Fprintf(&b, "# This backup is split into .partNNN%s files; run this for each part.\n", archive_extension(task))
+	}
+	if task.SourceListFile != "" {
+		fmt.Fprintf(&b, "# Paths listed from %s outside the source are stored\n", task.SourceListFile)
+		fmt.Fprintf(&b, "# by their full path; extract those with destination /.\n")
+	}
+	fmt.Fprintf(&b, "set -e\n")
+	fmt.Fprintf(&b, "ARCHIVE=${1:-%s}\n", shell_quote(archive))
+	fmt.Fprintf(&b, "DEST=${2:-%s}\n", shell_quote(filepath.Dir(filepath.Clean(task.BackupSource))))
+	exclude := restore_script_name
+	if task.DriftBaseline != "" {
+		exclude += " " + drift_report_name
+	}
+	fmt.Fprintf(&b, "unzip -o \"$ARCHIVE\" -x %s -d \"$DEST\"\n", exclude)
+	return b.String()
+}
+
+func database_restore_script(task BackupTask, dump string) string {
+	reader := "cat"
+	switch filepath.Ext(dump) {
+	case ".gz":
+		reader = "gzip -dc"
+	case ".zst":
+		reader = "zstd -dc"
+	case ".xz":
+		reader = "xz -dc"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "#!/bin/sh\n")
+	fmt.Fprintf(&b, "# Restore script for goBack backup %s\n", dump)
+	fmt.Fprintf(&b, "# Database: %s\n", task.Database)
+	fmt.Fprintf(&b, "# Created:  %s\n", time.Now().Format(time.RFC3339))
+	if task.SchemaOnly {
+		fmt.Fprintf(&b, "# This is a schema-only dump; it restores table structure without data.\n")
+	}
+	fmt.Fprintf(&b, "#\n")
+	fmt.Fprintf(&b, "# Usage: sh %s%s [dump] [database]\n", dump, restore_script_suffix)
+	fmt.Fprintf(&b, "# Create the database first if it does not exist.\n")
+	fmt.Fprintf(&b, "set -e\n")
+	fmt.Fprintf(&b, "DUMP=${1:-\"$(dirname \"$0\")\"/%s}\n", shell_quote(dump))
+	fmt.Fprintf(&b, "DB=${2:-%s}\n", shell_quote(task.Database))
+	fmt.Fprintf(&b, "%s \"$DUMP\" | mysql \"$DB\"\n", reader)
+	return b.String()
+}
+
+// add_restore_script stores restore.sh at the archive root.
+func add_restore_script(archive *zip.Writer, task BackupTask, target string) error {
+	header := &zip.FileHeader{
+		Name:     restore_script_name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	}
+	header.SetMode(0755)
+	writer, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write([]byte(archive_restore_script(task, filepath.Base(final_name(target)))))
+	return err
+}
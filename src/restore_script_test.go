@@ -0,0 +1,133 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"site", "'site'"},
+		{"/srv/my site", "'/srv/my site'"},
+		{"it's", `'it'\''s'`},
+		{"", "''"},
+	}
+	for _, tt := range tests {
+		if got := shell_quote(tt.value); got != tt.want {
+			t.Errorf("shell_quote(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestArchiveRestoreScript(t *testing.T) {
+	if _, err := exec.LookPath("unzip"); err != nil {
+		t.Skip("unzip not installed")
+	}
+	tests := []struct {
+		name string
+		task BackupTask
+	}{
+		{"plain", BackupTask{}},
+		{"drift report", BackupTask{drift: &driftReport{added: []string{"site/new.conf"}}, DriftBaseline: "/srv/golden"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, "src", "site")
+			store := filepath.Join(dir, "store")
+			os.MkdirAll(source, 0755)
+			os.MkdirAll(store, 0755)
+			os.WriteFile(filepath.Join(source, "index.html"), []byte("<html>"), 0644)
+			task := tt.task
+			task.Website, task.BackupSource, task.StorePath, task.RestoreScript = "site", source, store, true
+
+			files, err := archive_source(task, filepath.Join(store, "site-20261014-100000.zip"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader, err := zip.OpenReader(files[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var script []byte
+			for _, file := range reader.File {
+				if file.Name == restore_script_name {
+					rc, _ := file.Open()
+					script, _ = io.ReadAll(rc)
+					rc.Close()
+				}
+			}
+			reader.Close()
+			if !strings.Contains(string(script), "ARCHIVE=${1:-'site-20261014-100000.zip'}") {
+				t.Fatalf("restore.sh does not default to the archive's name:\n%s", script)
+			}
+
+			// Run it as a user would after fetching the archive.
+			script_path := filepath.Join(dir, restore_script_name)
+			os.WriteFile(script_path, script, 0755)
+			dest := filepath.Join(dir, "restored")
+			os.MkdirAll(dest, 0755)
+			if output, err := exec.Command("sh", script_path, files[0], dest).CombinedOutput(); err != nil {
+				t.Fatalf("restore.sh failed: %v\n%s", err, output)
+			}
+			if data, err := os.ReadFile(filepath.Join(dest, "site", "index.html")); err != nil || string(data) != "<html>" {
+				t.Errorf("restored index.html = %q, %v", data, err)
+			}
+			for _, name := range []string{restore_script_name, drift_report_name} {
+				if _, err := os.Stat(filepath.Join(dest, name)); err == nil {
+					t.Errorf("restore.sh extracted %s", name)
+				}
+			}
+		})
+	}
+}
+
+func TestDatabaseRestoreScript(t *testing.T) {
+	tests := []struct {
+		dump   string
+		reader string
+	}{
+		{"shop-20261014-100000.sql", "cat \"$DUMP\""},
+		{"shop-20261014-100000.sql.gz", "gzip -dc \"$DUMP\""},
+		{"shop-20261014-100000.sql.zst", "zstd -dc \"$DUMP\""},
+		{"shop-20261014-100000.sql.xz", "xz -dc \"$DUMP\""},
+	}
+	for _, tt := range tests {
+		script := database_restore_script(BackupTask{Database: "shop"}, tt.dump)
+		if !strings.Contains(script, tt.reader+" | mysql \"$DB\"") {
+			t.Errorf("%s: script does not read the dump with %s:\n%s", tt.dump, tt.reader, script)
+		}
+		if !strings.Contains(script, "DB=${2:-'shop'}") {
+			t.Errorf("%s: script does not default to database shop", tt.dump)
+		}
+	}
+
+	// A dump written by backup_database gets its script next to it, and
+	// the script feeds the dump to mysql.
+	dir := t.TempDir()
+	mysqldump, mysql := fake_mysql(t, dir)
+	task := BackupTask{Database: "shop", StorePath: dir, MysqldumpPath: mysqldump, RestoreScript: true}
+	files, err := backup_database(task, "", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := filepath.Join(dir, "restored.sql")
+	os.WriteFile(mysql, []byte("#!/bin/sh\ncat > "+restored+"\n"), 0755)
+	cmd := exec.Command("sh", files[0]+restore_script_suffix)
+	cmd.Env = append(os.Environ(), "PATH="+dir+":"+os.Getenv("PATH"))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("restore script failed: %v\n%s", err, output)
+	}
+	dump, _ := os.ReadFile(files[0])
+	if data, _ := os.ReadFile(restored); string(data) != string(dump) {
+		t.Errorf("mysql got %q, want the dump %q", data, dump)
+	}
+}
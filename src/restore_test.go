@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRestoreTask(t *testing.T) {
+	tests := []struct {
+		name    string
+		task    string
+		check   string
+		fails   bool
+		message string
+	}{
+		{"no check", "site", "", false, ""},
+		{"check passes", "site", `test -f site/index.html && test -f "$GOBACK_RESTORE_DEST/site/index.html" && test -f "$GOBACK_RESTORE_ARCHIVE"`, false, ""},
+		{"check fails", "site", "grep -q missing site/index.html", true, "Restore Check FAILED: site"},
+		{"unknown task", "other", "", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := record_messages(t)
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "site-20261014-100000.zip")
+			write_test_zip(t, archive)
+			dest := filepath.Join(dir, "restored")
+			config := Config{
+				Telegram:     Telegram{BotToken: "token", ChatID: 1, Enable: true},
+				WebsiteTasks: []BackupTask{{Website: "site", PostRestoreCheck: tt.check}},
+			}
+			err := restore_task(config, tt.task, archive, dest, "")
+			if (err != nil) != tt.fails {
+				t.Fatalf("restore_task = %v, want failure %v", err, tt.fails)
+			}
+			if got := strings.Join(bot.messages(), ","); got != tt.message {
+				t.Errorf("sent %q, want %q", got, tt.message)
+			}
+			if tt.task != "site" {
+				return
+			}
+			if _, err := os.Stat(filepath.Join(dest, "site", "index.html")); err != nil {
+				t.Errorf("archive was not restored: %v", err)
+			}
+		})
+	}
+}
+
+func TestRestoreMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"", "site/index.html", true},
+		{"site/index.html", "site/index.html", true},
+		{"site/*.html", "site/index.html", true},
+		{"site/*.html", "site/css/site.css", false},
+		{"site/css", "site/css/site.css", true},
+		{"site/css", "site/css/", true},
+		{"site/css", "site/cssold/site.css", false},
+		{"site/*/site.css", "site/css/site.css", true},
+		{"site", "blog/site", false},
+	}
+	for _, tt := range tests {
+		if got := restore_match(tt.pattern, tt.name); got != tt.match {
+			t.Errorf("restore_match(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.match)
+		}
+	}
+}
+
+func TestSelectiveRestore(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "site")
+	store := filepath.Join(dir, "store")
+	write_tree(t, source, map[string]string{"index.html": "<html>", "about.html": "about", "css/site.css": "body {}"})
+	os.MkdirAll(store, 0755)
+	tests := []struct {
+		name string
+		task BackupTask
+	}{
+		{"zip", BackupTask{}},
+		{"solid", BackupTask{Solid: "gzip"}},
+		{"delta", BackupTask{DeltaMode: true}},
+	}
+	for i, tt := range tests {
+		task := tt.task
+		task.Website, task.BackupSource, task.StorePath = "site", source, store
+		files, err := archive_source(task, filepath.Join(store, fmt.Sprintf("site-20261014-1%d0000%s", i, archive_extension(task))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		patterns := []struct {
+			pattern string
+			want    []string
+			fails   bool
+		}{
+			{"site/css", []string{"site/css/site.css=body {}"}, false},
+			{"site/*.html", []string{"site/about.html=about", "site/index.html=<html>"}, false},
+			{"site/missing", nil, true},
+			{"site/[", nil, true},
+		}
+		for _, p := range patterns {
+			dest := filepath.Join(dir, "restored", tt.name, p.pattern)
+			err := restore_backup(files[0], dest, p.pattern)
+			if (err != nil) != p.fails {
+				t.Errorf("%s -path %q: restore_backup = %v, want failure %v", tt.name, p.pattern, err, p.fails)
+				continue
+			}
+			if got := tree_contents(t, dest); strings.Join(got, ",") != strings.Join(p.want, ",") {
+				t.Errorf("%s -path %q restored %v, want %v", tt.name, p.pattern, got, p.want)
+			}
+		}
+	}
+
+	// A delta's deletions are replayed for the matching paths only.
+	os.Remove(filepath.Join(source, "about.html"))
+	task := BackupTask{Website: "site", BackupSource: source, StorePath: store, DeltaMode: true}
+	files, err := archive_source(task, filepath.Join(store, "site-20261014-130000.zip"))
+	if err != nil || !is_delta_name(filepath.Base(files[0])) {
+		t.Fatalf("archive_source = %v, %v, want a delta", files, err)
+	}
+	dest := filepath.Join(dir, "restored", "after deletion")
+	if err := restore_backup(files[0], dest, "site/*.html"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(tree_contents(t, dest), ","); got != "site/index.html=<html>" {
+		t.Errorf("restored %s after the deletion", got)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// GFSRetention keeps the newest backup of each of the last Hourly hours,
+// Daily days, Weekly ISO weeks and Monthly months that have a backup. A
+// backup kept by any tier survives.
+type GFSRetention struct {
+	Hourly  int `json:"Hourly,omitempty"`
+	Daily   int `json:"Daily,omitempty"`
+	Weekly  int `json:"Weekly,omitempty"`
+	Monthly int `json:"Monthly,omitempty"`
+}
+
+func validate_gfs(gfs *GFSRetention) error {
+	if gfs.Hourly < 0 || gfs.Daily < 0 || gfs.Weekly < 0 || gfs.Monthly < 0 {
+		return fmt.Errorf("invalid GFS: counts cannot be negative")
+	}
+	if gfs.Hourly+gfs.Daily+gfs.Weekly+gfs.Monthly == 0 {
+		return fmt.Errorf("invalid GFS: every tier is 0, nothing would be kept")
+	}
+	return nil
+}
+
+// gfs_keep returns the sets kept by gfs, bucketing their times in location.
+func gfs_keep(sets []*backupSet, gfs *GFSRetention, location *time.Location) map[*backupSet]bool {
+	sorted := append([]*backupSet(nil), sets...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].modTime.After(sorted[j].modTime)
+	})
+
+	tiers := []struct {
+		count  int
+		bucket func(t time.Time) string
+	}{
+		{gfs.Hourly, func(t time.Time) string { return t.Format("2006010215") }},
+		{gfs.Daily, func(t time.Time) string { return t.Format("20060102") }},
+		{gfs.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", year, week)
+		}},
+		{gfs.Monthly, func(t time.Time) string { return t.Format("200601") }},
+	}
+
+	keep := map[*backupSet]bool{}
+	for _, tier := range tiers {
+		seen := map[string]bool{}
+		for _, set := range sorted {
+			if len(seen) == tier.count {
+				break
+			}
+			bucket := tier.bucket(set.modTime.In(location))
+			if !seen[bucket] {
+				// Newest first, so this is the newest backup of its bucket.
+				seen[bucket] = true
+				keep[set] = true
+			}
+		}
+	}
+	return keep
+}
+
+// prune_gfs removes the sets gfs_keep does not keep, except those still in
+// the grace period.
+func prune_gfs(task BackupTask, sets []*backupSet, grace time.Duration) {
+	location, err := time.LoadLocation(task.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	keep := gfs_keep(sets, task.GFS, location)
+	for _, set := range sets {
+		if keep[set] || time.Since(set.modTime) < grace {
+			continue
+		}
+		for _, name := range set.files {
+			os.Remove(task.StorePath + "/" + name)
+		}
+	}
+}
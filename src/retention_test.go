@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateGFS(t *testing.T) {
+	tests := []struct {
+		gfs   GFSRetention
+		valid bool
+	}{
+		{GFSRetention{Daily: 7}, true},
+		{GFSRetention{Hourly: 24, Daily: 7, Weekly: 4, Monthly: 12}, true},
+		{GFSRetention{}, false},
+		{GFSRetention{Daily: 7, Weekly: -1}, false},
+	}
+	for _, tt := range tests {
+		if err := validate_gfs(&tt.gfs); (err == nil) != tt.valid {
+			t.Errorf("validate_gfs(%+v) = %v, want valid %v", tt.gfs, err, tt.valid)
+		}
+	}
+}
+
+func TestGFSKeep(t *testing.T) {
+	// Two backups a day, at 06:00 and 18:00 UTC, from Thursday 2026-08-13
+	// to Wednesday 2026-10-14.
+	var sets []*backupSet
+	for day := time.Date(2026, 8, 13, 0, 0, 0, 0, time.UTC); !day.After(time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)); day = day.AddDate(0, 0, 1) {
+		for _, hour := range []int{6, 18} {
+			at := day.Add(time.Duration(hour) * time.Hour)
+			sets = append(sets, &backupSet{key: at.Format("20060102-15"), modTime: at})
+		}
+	}
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	tests := []struct {
+		name     string
+		gfs      GFSRetention
+		location *time.Location
+		want     []string
+	}{
+		{"hourly", GFSRetention{Hourly: 3}, time.UTC, []string{"20261013-18", "20261014-06", "20261014-18"}},
+		{"daily keeps the last of each day", GFSRetention{Daily: 2}, time.UTC, []string{"20261013-18", "20261014-18"}},
+		{"weekly", GFSRetention{Weekly: 2}, time.UTC, []string{"20261011-18", "20261014-18"}},
+		{"monthly", GFSRetention{Monthly: 3}, time.UTC, []string{"20260831-18", "20260930-18", "20261014-18"}},
+		{"tiers overlap", GFSRetention{Daily: 2, Weekly: 2}, time.UTC, []string{"20261011-18", "20261013-18", "20261014-18"}},
+		// 18:00 UTC is already the next day in Tokyo.
+		{"days in Timezone", GFSRetention{Daily: 2}, tokyo, []string{"20261014-06", "20261014-18"}},
+		{"more tiers than backups", GFSRetention{Monthly: 12}, time.UTC, []string{"20260831-18", "20260930-18", "20261014-18"}},
+	}
+	for _, tt := range tests {
+		keep := gfs_keep(sets, &tt.gfs, tt.location)
+		var got []string
+		for set := range keep {
+			got = append(got, set.key)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: kept %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPruneGFS(t *testing.T) {
+	dir := t.TempDir()
+	ages := map[string]time.Duration{}
+	for day := 0; day < 10; day++ {
+		ages[fmt.Sprintf("site-202610%02d-100000.zip", 14-day)] = time.Duration(day) * 24 * time.Hour
+	}
+	write_backups(t, dir, ages)
+	os.WriteFile(filepath.Join(dir, "shop-20261001-100000.sql"), []byte("other task"), 0644)
+
+	check_backup_file_num(BackupTask{Website: "site", StorePath: dir, Timezone: "UTC", GFS: &GFSRetention{Daily: 3}})
+	want := "shop-20261001-100000.sql,site-20261012-100000.zip,site-20261013-100000.zip,site-20261014-100000.zip"
+	if got := strings.Join(remaining(dir), ","); got != want {
+		t.Errorf("kept %s, want %s", got, want)
+	}
+}
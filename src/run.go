@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// run_ctx is cancelled once the run exceeds MaxRunDuration. Every command
+// goBack starts is tied to it, and archiving stops mid-file.
+var run_ctx = context.Background()
+
+// contextReader fails once run_ctx is cancelled, so a cancelled run stops
+// in the middle of a large file instead of after it.
+type contextReader struct {
+	reader io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := run_ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
+// run_cancel_grace is how long a cancelled run waits for its tasks to
+// unwind before reporting and exiting anyway.
+const run_cancel_grace = 10 * time.Second
+
+// safe_mode is set by SafeMode. goBack then only creates and copies
+// backups: nothing is rotated or pruned, and uploads never delete on the
+// remote.
+var safe_mode bool
+
+// run_command is exec.Command tied to run_ctx. The command gets its own
+// process group, and cancelling kills the whole group, so children the
+// command started, e.g. mysqldump under sh, stop with it.
+func run_command(name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(run_ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return cmd
+}
+
+// binary is the configured path of a client tool, or its bare name to look
+// up in PATH when none is set.
+func binary(path, name string) string {
+	if path != "" {
+		return path
+	}
+	return name
+}
+
+// runTracker records the outcome of each task that finished before the run
+// was cancelled.
+type runTracker struct {
+	mu       sync.Mutex
+	finished map[string]bool // task name -> succeeded
+}
+
+func (r *runTracker) finish(name string, err error) {
+	if run_ctx.Err() != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finished[name] = err == nil
+}
+
+// failed counts the tasks that failed, treating every task that did not
+// finish in time as failed, and returns the names of those cut off.
+func (r *runTracker) failed(names []string) (int, []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	failed := 0
+	var cut []string
+	for _, name := range names {
+		ok, done := r.finished[name]
+		if !done {
+			cut = append(cut, name)
+		}
+		if !ok {
+			failed++
+		}
+	}
+	sort.Strings(cut)
+	return failed, cut
+}
+
+// wait_run waits for the tasks. Once the run is cancelled it gives them
+// run_cancel_grace to unwind, then stops waiting.
+func wait_run(wg *sync.WaitGroup) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-run_ctx.Done():
+		select {
+		case <-done:
+		case <-time.After(run_cancel_grace):
+		}
+	}
+}
+
+// report_cut_off alerts which tasks were stopped by MaxRunDuration or
+// RunTimeout, as told by reason, and which completed.
+func report_cut_off(config Config, reason string, names, cut []string) {
+	is_cut := map[string]bool{}
+	for _, name := range cut {
+		is_cut[name] = true
+	}
+	var completed []string
+	for _, name := range names {
+		if !is_cut[name] {
+			completed = append(completed, name)
+		}
+	}
+	sort.Strings(completed)
+	message := "Backup run " + reason +
+		"\nCut off: " + strings.Join(cut, ", ") +
+		"\nCompleted: " + strings.Join(completed, ", ")
+	log.Print(message)
+	send_message(config.Telegram.BotToken, config.Telegram.ChatID, message, config.Telegram.Enable)
+}
+
+// timeout_exit_code is the exit code of a run cut off by RunTimeout:
+// PartialExitCode, or the failure code when it is not set.
+func timeout_exit_code(config Config) int {
+	if config.PartialExitCode != nil {
+		return *config.PartialExitCode
+	}
+	return exit_code(config, 1, 1)
+}
+
+// watch_run_timeout makes RunTimeout a hard ceiling. Cancelling run_ctx
+// stops the tasks, and the run then reports and exits as usual; should it
+// still be running run_cancel_grace later, e.g. stuck in a Freeze command
+// or a Telegram request, it is ended here.
+func watch_run_timeout(config Config, limit time.Duration) {
+	time.AfterFunc(limit+run_cancel_grace, func() {
+		message := fmt.Sprintf("Backup run exceeded time budget RunTimeout %s and did not stop within %s", config.RunTimeout, run_cancel_grace)
+		log.Print(message)
+		sent := make(chan struct{})
+		go func() {
+			send_message(config.Telegram.BotToken, config.Telegram.ChatID, message, config.Telegram.Enable)
+			close(sent)
+		}()
+		// Telegram may be what the run is stuck on.
+		select {
+		case <-sent:
+		case <-time.After(run_cancel_grace):
+		}
+		// A Thaw that hangs as well must not keep the run alive.
+		thawed := make(chan struct{})
+		go func() {
+			thaw_all()
+			close(thawed)
+		}()
+		select {
+		case <-thawed:
+		case <-time.After(run_cancel_grace):
+		}
+		os.Exit(timeout_exit_code(config))
+	})
+}
+
+// partial_prefix marks a backup file that is still being written. The
+// hidden name keeps it out of rotation, heal and uploads until
+// commit_partial_files gives it its final name.
+const partial_prefix = ".partial."
+
+// partial_filter keeps unfinished files out of rclone transfers.
+var partial_filter = " --exclude " + shell_quote(partial_prefix+"*")
+
+func partial_name(path string) string {
+	return filepath.Join(filepath.Dir(path), partial_prefix+filepath.Base(path))
+}
+
+// final_name is the name path gets once committed.
+func final_name(path string) string {
+	return filepath.Join(filepath.Dir(path), strings.TrimPrefix(filepath.Base(path), partial_prefix))
+}
+
+// commit_partial_files renames files written under partial_name, and
+// their sidecars, to their final names and returns those.
+func commit_partial_files(files []string) ([]string, error) {
+	var committed []string
+	for _, name := range files {
+		final := final_name(name)
+		if err := os.Rename(name, final); err != nil {
+			return committed, err
+		}
+		for _, suffix := range backup_sidecar_suffixes {
+			if _, err := os.Stat(name + suffix); err == nil {
+				if err := os.Rename(name+suffix, final+suffix); err != nil {
+					return committed, err
+				}
+			}
+		}
+		committed = append(committed, final)
+	}
+	return committed, nil
+}
+
+// remove_backup_files deletes the files of a backup and their sidecars,
+// e.g. the partial archive a cancelled backup left behind, so it is never
+// rotated or uploaded as a backup.
+func remove_backup_files(files []string) {
+	for _, name := range files {
+		os.Remove(name)
+		for _, suffix := range backup_sidecar_suffixes {
+			os.Remove(name + suffix)
+		}
+	}
+}
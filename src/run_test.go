@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cancellingReader cancels the run after its first read, as MaxRunDuration
+// would while a large file is being archived.
+type cancellingReader struct {
+	reader io.Reader
+	cancel context.CancelFunc
+}
+
+func (r cancellingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.cancel()
+	return n, err
+}
+
+func TestContextReader(t *testing.T) {
+	defer func(ctx context.Context) { run_ctx = ctx }(run_ctx)
+	ctx, cancel := context.WithCancel(context.Background())
+	run_ctx = ctx
+	source := strings.NewReader(strings.Repeat("x", 1<<20))
+	reader := contextReader{cancellingReader{source, cancel}}
+	n, err := io.Copy(io.Discard, reader)
+	if err != context.Canceled {
+		t.Fatalf("err = %v, want %v", err, context.Canceled)
+	}
+	if n >= 1<<20 {
+		t.Errorf("copied all %d bytes after cancelling", n)
+	}
+}
+
+func TestArchiveSourceCancelled(t *testing.T) {
+	tests := []struct {
+		name      string
+		cancelled bool
+	}{
+		{"complete run", false},
+		{"cancelled run", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(ctx context.Context) { run_ctx = ctx }(run_ctx)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelled {
+				cancel()
+			}
+			run_ctx = ctx
+
+			dir := t.TempDir()
+			source := filepath.Join(dir, "src")
+			store := filepath.Join(dir, "store")
+			os.MkdirAll(source, 0755)
+			os.MkdirAll(store, 0755)
+			os.WriteFile(filepath.Join(source, "a.txt"), []byte("hello"), 0644)
+			task := BackupTask{Name: "conf", BackupSource: source, StorePath: store, FileList: true}
+			target := filepath.Join(store, "conf-20261014-100000.zip")
+
+			files, err := archive_source(task, target)
+			if (err != nil) != tt.cancelled {
+				t.Fatalf("err = %v, want failure %v", err, tt.cancelled)
+			}
+			entries, _ := os.ReadDir(store)
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			want := []string{"conf-20261014-100000.zip", "conf-20261014-100000.zip" + file_list_suffix}
+			if tt.cancelled {
+				want = nil
+			}
+			if strings.Join(names, ",") != strings.Join(want, ",") {
+				t.Errorf("StorePath holds %v, want %v", names, want)
+			}
+			if !tt.cancelled && (len(files) != 1 || files[0] != target) {
+				t.Errorf("files = %v, want [%s]", files, target)
+			}
+		})
+	}
+}
+
+func TestRemovePartialFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		removed bool
+	}{
+		{partial_prefix + "conf-20261014-100000.zip", true},
+		{partial_prefix + "conf-20261014-100000.part002.zip", true},
+		{partial_prefix + "conf-staging-20261014-100000.zip", false},
+		{"conf-20261014-100000.zip", false},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		os.WriteFile(filepath.Join(dir, tt.name), nil, 0644)
+	}
+	remove_partial_files(BackupTask{Name: "conf", BackupSource: dir, StorePath: dir})
+	for _, tt := range tests {
+		_, err := os.Stat(filepath.Join(dir, tt.name))
+		if removed := os.IsNotExist(err); removed != tt.removed {
+			t.Errorf("%s: removed = %v, want %v", tt.name, removed, tt.removed)
+		}
+	}
+}
+
+func TestBinary(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", "mysqldump"},
+		{"/opt/mysql/bin/mysqldump", "/opt/mysql/bin/mysqldump"},
+	}
+	for _, tt := range tests {
+		if got := binary(tt.path, "mysqldump"); got != tt.want {
+			t.Errorf("binary(%q) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+
+	// Without an override the tool is looked up in PATH; an override is
+	// used even when PATH holds another one.
+	dir := t.TempDir()
+	mysqldump, _ := fake_mysql(t, dir)
+	override := filepath.Join(dir, "override")
+	os.WriteFile(override, []byte("#!/bin/sh\necho override\n"), 0755)
+	t.Setenv("PATH", dir+":"+os.Getenv("PATH"))
+	for _, path := range []string{"", override} {
+		files, err := backup_database(BackupTask{Database: "shop", StorePath: dir, MysqldumpPath: path}, "", 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dump, _ := os.ReadFile(files[0])
+		if got, want := strings.HasPrefix(string(dump), "override"), path == override; got != want {
+			t.Errorf("MysqldumpPath %q dumped %q", path, dump)
+		}
+		os.Remove(files[0])
+	}
+	if _, err := os.Stat(mysqldump + ".args"); err != nil {
+		t.Error("mysqldump from PATH did not run")
+	}
+}
+
+func TestSafeMode(t *testing.T) {
+	tests := []struct {
+		safe    bool
+		left    string
+		command string // the rclone command of the upload
+		remote  int    // files left on the remote after pruning
+	}{
+		{false, "site-20261014-100000.zip", "sync", 1},
+		{true, partial_prefix + "site-20261014-110000.zip,site-20261012-100000.zip,site-20261013-100000.part002.zip,site-20261014-100000.zip", "copy", 3},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		store := filepath.Join(dir, "store")
+		os.MkdirAll(store, 0755)
+		write_backups(t, store, map[string]time.Duration{
+			"site-20261012-100000.zip":         48 * time.Hour,
+			"site-20261013-100000.part002.zip": 24 * time.Hour,
+			"site-20261014-100000.zip":         time.Hour,
+		})
+		os.WriteFile(filepath.Join(store, partial_prefix+"site-20261014-110000.zip"), nil, 0644)
+		remote := filepath.Join(dir, "remote")
+		write_tree(t, remote, map[string]string{"site-20261012-100000.zip": "", "site-20261013-100000.zip": "", "site-20261014-100000.zip": ""})
+		rclone := filepath.Join(dir, "rclone")
+		script := "#!/bin/sh\necho \"$@\" >> " + filepath.Join(dir, "args") + "\n" +
+			"case \"$1\" in lsf) ls " + remote + ";; deletefile) rm " + remote + "/$(basename \"$2\");; esac\n"
+		os.WriteFile(rclone, []byte(script), 0755)
+
+		saved := safe_mode
+		safe_mode = tt.safe
+		task := BackupTask{Website: "site", StorePath: store, RemotePath: "r:site", RclonePath: rclone, MaxBackup: 1}
+		heal_backup_sets(task)
+		check_backup_file_num(task)
+		err := copy_backup_to_onedrive(task, "", 0, false)
+		if err == nil {
+			err = prune_remote(task)
+		}
+		safe_mode = saved
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := strings.Join(remaining(store), ","); got != tt.left {
+			t.Errorf("SafeMode %v: StorePath holds %s, want %s", tt.safe, got, tt.left)
+		}
+		args, _ := os.ReadFile(filepath.Join(dir, "args"))
+		if !strings.HasPrefix(string(args), tt.command+" ") {
+			t.Errorf("SafeMode %v: rclone ran %q, want %s", tt.safe, args, tt.command)
+		}
+		if got := len(remaining(remote)); got != tt.remote {
+			t.Errorf("SafeMode %v: remote holds %v, want %d files", tt.safe, remaining(remote), tt.remote)
+		}
+	}
+}
+
+func TestTimeoutExitCode(t *testing.T) {
+	code := func(n int) *int { return &n }
+	tests := []struct {
+		name   string
+		config Config
+		want   int
+	}{
+		{"defaults", Config{}, 1},
+		{"partial", Config{FailureExitCode: code(20), PartialExitCode: code(30)}, 30},
+		{"failure only", Config{FailureExitCode: code(20)}, 20},
+		{"partial zero", Config{PartialExitCode: code(0)}, 0},
+	}
+	for _, tt := range tests {
+		if got := timeout_exit_code(tt.config); got != tt.want {
+			t.Errorf("%s: timeout_exit_code = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReportCutOff(t *testing.T) {
+	bot := record_messages(t)
+	config := Config{Telegram: Telegram{BotToken: "token", ChatID: 1, Enable: true}}
+	report_cut_off(config, "exceeded time budget RunTimeout 1h", []string{"site", "shop", "blog"}, []string{"shop"})
+	want := "Backup run exceeded time budget RunTimeout 1h\nCut off: shop\nCompleted: blog, site"
+	if got := strings.Join(bot.messages(), ","); got != want {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// run_log_suffix names the sidecar holding an EmbedRunLog task's record of
+// how its backup was made.
+const run_log_suffix = ".runlog.txt"
+
+// runLog records the commands a task ran, their output and how long they
+// took. A nil runLog records nothing, so callers need not check whether
+// EmbedRunLog is on.
+type runLog struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *runLog) printf(format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, time.Now().Format("2006-01-02 15:04:05.000")+" "+fmt.Sprintf(format, args...))
+}
+
+// command records a finished command: its command line, how it ended and
+// its output, indented.
+func (l *runLog) command(cmdline string, start time.Time, err error, output string) {
+	if l == nil {
+		return
+	}
+	l.printf("$ %s", cmdline)
+	result := "exit 0"
+	if err != nil {
+		result = err.Error()
+	}
+	l.printf("  %s after %s", result, time.Since(start).Round(time.Millisecond))
+	if output = strings.TrimSpace(output); output != "" {
+		l.mu.Lock()
+		for _, line := range strings.Split(output, "\n") {
+			l.lines = append(l.lines, "    "+line)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// write_run_log stores the task's run log as a sidecar of its backup.
+func write_run_log(task BackupTask, files []string) error {
+	if task.runlog == nil || len(files) == 0 {
+		return nil
+	}
+	task.runlog.mu.Lock()
+	defer task.runlog.mu.Unlock()
+	return os.WriteFile(files[0]+run_log_suffix, []byte(strings.Join(task.runlog.lines, "\n")+"\n"), 0644)
+}
+
+// archived records how archiving the task's source into files went.
+func (l *runLog) archived(task BackupTask, files []string, start time.Time, err error) {
+	if l == nil {
+		return
+	}
+	took := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		l.printf("Archiving %s failed after %s: %v", task.BackupSource, took, err)
+		return
+	}
+	var written []string
+	for _, name := range files {
+		if info, err := os.Stat(name); err == nil {
+			written = append(written, fmt.Sprintf("%s (%s)", filepath.Base(name), format_bytes(uint64(info.Size()))))
+		}
+	}
+	l.printf("Archived %s into %s in %s", task.BackupSource, strings.Join(written, ", "), took)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunLogCommand(t *testing.T) {
+	tests := []struct {
+		err    error
+		output string
+		want   []string
+	}{
+		{nil, "", []string{`\$ mysqldump shop`, `  exit 0 after \d+m?s`}},
+		{errors.New("exit status 2"), "mysqldump: Got error: 1045\nAccess denied\n", []string{`\$ mysqldump shop`, `  exit status 2 after \d+m?s`, `    mysqldump: Got error: 1045`, `    Access denied`}},
+	}
+	for _, tt := range tests {
+		log := &runLog{}
+		log.command("mysqldump shop", time.Now(), tt.err, tt.output)
+		if len(log.lines) != len(tt.want) {
+			t.Fatalf("logged %q, want %d lines", log.lines, len(tt.want))
+		}
+		for i, pattern := range tt.want {
+			if !regexp.MustCompile(`^(\d{4}-\d\d-\d\d \d\d:\d\d:\d\d\.\d{3} )?` + pattern + `$`).MatchString(log.lines[i]) {
+				t.Errorf("line %d = %q, want %s", i+1, log.lines[i], pattern)
+			}
+		}
+	}
+
+	var none *runLog
+	none.printf("ignored")
+	none.command("true", time.Now(), nil, "")
+	none.archived(BackupTask{}, nil, time.Now(), nil)
+}
+
+func TestEmbedRunLog(t *testing.T) {
+	tests := []struct {
+		name  string
+		task  BackupTask
+		embed bool
+		want  []string
+	}{
+		{"website", BackupTask{Website: "site"}, true, []string{"goBack backup of site", "Archived ", "into site-"}},
+		{"database", BackupTask{Database: "shop"}, true, []string{"goBack backup of shop", "$ ", "mysqldump", "exit 0 after"}},
+		{"off", BackupTask{Website: "site"}, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, "site")
+			store := filepath.Join(dir, "store")
+			write_tree(t, source, map[string]string{"index.html": "<html>"})
+			os.MkdirAll(store, 0755)
+			mysqldump, _ := fake_mysql(t, dir)
+			task := tt.task
+			task.BackupSource, task.StorePath, task.MysqldumpPath, task.MaxBackup, task.EmbedRunLog = source, store, mysqldump, 1, tt.embed
+			task.RclonePath = "true"
+			backup := backup_website
+			if task.Database != "" {
+				backup = backup_database
+			}
+			if err := handle_task(task, "", 0, false, backup); err != nil {
+				t.Fatal(err)
+			}
+			var logs []string
+			for _, name := range remaining(store) {
+				if strings.HasSuffix(name, run_log_suffix) {
+					logs = append(logs, name)
+				}
+			}
+			if len(logs) != map[bool]int{true: 1, false: 0}[tt.embed] {
+				t.Fatalf("StorePath holds %v", remaining(store))
+			}
+			if !tt.embed {
+				return
+			}
+			data, _ := os.ReadFile(filepath.Join(store, logs[0]))
+			for _, want := range tt.want {
+				if !strings.Contains(string(data), want) {
+					t.Errorf("run log lacks %q:\n%s", want, data)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// load_secrets merges the credentials in the config's SecretsFile into
+// config. A relative path is taken from the directory of the config at
+// config_path. The file may only hold the telegram block, so it cannot
+// change what gets backed up.
+func load_secrets(config_path string, config *Config) error {
+	path := config.SecretsFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(config_path), path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0007 != 0 {
+		log.Printf("Warning: secrets file %s is accessible to all users (mode %04o), run chmod 600 on it", path, info.Mode().Perm())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var secrets map[string]json.RawMessage
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for key, value := range secrets {
+		if key != "telegram" {
+			return fmt.Errorf("%s: unexpected key %q, a secrets file only holds \"telegram\"", path, key)
+		}
+		// Unmarshalling over the loaded block keeps the fields the
+		// secrets file leaves out, e.g. enable.
+		if err := json.Unmarshal(value, &config.Telegram); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
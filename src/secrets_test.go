@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSecrets(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string // SecretsFile, relative to the config's directory
+		secrets string
+		mode    os.FileMode
+		want    Telegram
+		fails   bool
+		warns   bool
+	}{
+		{"relative", "secrets.json", `{"telegram": {"BotToken": "secret", "ChatID": 42}}`, 0600, Telegram{BotToken: "secret", ChatID: 42, Enable: true}, false, false},
+		{"absolute", "ABS", `{"telegram": {"BotToken": "secret"}}`, 0600, Telegram{BotToken: "secret", ChatID: 1, Enable: true}, false, false},
+		{"world readable", "secrets.json", `{"telegram": {"BotToken": "secret"}}`, 0644, Telegram{BotToken: "secret", ChatID: 1, Enable: true}, false, true},
+		{"other keys", "secrets.json", `{"telegram": {}, "WebsiteTasks": []}`, 0600, Telegram{}, true, false},
+		{"invalid JSON", "secrets.json", `{"telegram": `, 0600, Telegram{}, true, false},
+		{"missing", "missing.json", "", 0, Telegram{}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			secrets := filepath.Join(dir, "private", "secrets.json")
+			os.MkdirAll(filepath.Dir(secrets), 0755)
+			if tt.secrets != "" {
+				os.WriteFile(secrets, []byte(tt.secrets), tt.mode)
+				os.Chmod(secrets, tt.mode)
+			}
+			path := filepath.Join("private", tt.path)
+			if tt.path == "ABS" {
+				path = secrets
+			}
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			config := Config{SecretsFile: path, Telegram: Telegram{BotToken: "placeholder", ChatID: 1, Enable: true}}
+			err := load_secrets(filepath.Join(dir, "config.json"), &config)
+			if (err != nil) != tt.fails {
+				t.Fatalf("load_secrets = %v, want failure %v", err, tt.fails)
+			}
+			if !tt.fails && config.Telegram != tt.want {
+				t.Errorf("telegram = %+v, want %+v", config.Telegram, tt.want)
+			}
+			if got := strings.Contains(logs.String(), "accessible to all users"); got != tt.warns {
+				t.Errorf("warned %v, want %v: %s", got, tt.warns, logs.String())
+			}
+		})
+	}
+}
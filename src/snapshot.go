@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// config_snapshot_suffix names the sidecar holding the config a backup
+// was made with.
+const config_snapshot_suffix = ".config.json"
+
+const masked_secret = "********"
+
+// secret_key_pattern matches the config keys whose values are masked.
+var secret_key_pattern = regexp.MustCompile(`(?i)(token|password|passwd|secret|apikey|chatid)$`)
+
+// config_snapshot is the masked config written next to every backup when
+// IncludeConfigSnapshot is set, nil otherwise.
+var config_snapshot []byte
+
+// mask_config returns the effective config as indented JSON with the
+// Telegram credentials, every field named like a secret and the passwords
+// in URLs, e.g. an Elasticsearch address, masked.
+func mask_config(config Config) ([]byte, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(mask_value("", tree), "", "    ")
+}
+
+func mask_value(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = mask_value(k, child)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = mask_value(key, child)
+		}
+		return v
+	case string:
+		if secret_key_pattern.MatchString(key) && v != "" {
+			return masked_secret
+		}
+		return mask_url(v)
+	case float64:
+		if secret_key_pattern.MatchString(key) {
+			return masked_secret
+		}
+	}
+	return value
+}
+
+// mask_url masks the password of a URL with userinfo and returns any other
+// string unchanged.
+func mask_url(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	return u.Redacted()
+}
+
+// write_config_snapshot stores config_snapshot as a sidecar of the backup.
+func write_config_snapshot(files []string) error {
+	if config_snapshot == nil || len(files) == 0 {
+		return nil
+	}
+	return os.WriteFile(files[0]+config_snapshot_suffix, config_snapshot, 0600)
+}
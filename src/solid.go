@@ -0,0 +1,335 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// zstd_dict_suffix names the sidecar recording the dictionary a .tar.zst
+// was compressed with, which decompressing it needs too.
+const zstd_dict_suffix = ".zstdict"
+
+// solid_compressor returns the compressor named by the Solid option.
+func solid_compressor(name string) (compressor, bool) {
+	for _, c := range best_compressors {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return compressor{}, false
+}
+
+// solid_levels are the levels SolidLevel accepts for each Solid
+// compressor. The defaults are the strongest: 9 for gzip and xz, 19 for
+// zstd, whose higher levels need --ultra and far more memory.
+var solid_levels = map[string][2]int{
+	"gzip": {1, 9},
+	"zstd": {1, 19},
+	"xz":   {0, 9},
+}
+
+// solid_run returns the compression step of the task's Solid archive,
+// at SolidLevel when set.
+func solid_run(task BackupTask) func(source, target string) error {
+	c, _ := solid_compressor(task.Solid)
+	if task.SolidLevel == nil && task.ZstdDictPath == "" && c.name != "gzip" {
+		return c.run
+	}
+	level := solid_levels[c.name][1]
+	if task.SolidLevel != nil {
+		level = *task.SolidLevel
+	}
+	flag := fmt.Sprintf("-%d", level)
+	switch {
+	case task.ZstdDictPath != "":
+		return command_compressor("zstd", flag, "-q", "-c", "-D", task.ZstdDictPath)
+	case c.name == "gzip":
+		return gzip_compressor(level, task.GzipBlockSize, task.GzipWorkers)
+	case c.name == "zstd":
+		return command_compressor("zstd", flag, "-q", "-c")
+	}
+	return command_compressor("xz", flag, "-c")
+}
+
+// solid_extension is the extension of a Solid archive, e.g. .tar.gz.
+func solid_extension(task BackupTask) string {
+	c, _ := solid_compressor(task.Solid)
+	return ".tar" + c.ext
+}
+
+// createSolidArchive writes source into a tar and compresses the whole tar
+// as one stream, so many small files share one compression context instead
+// of being deflated one by one as in a zip.
+func createSolidArchive(task BackupTask, target string) error {
+	entries, err := source_entries(task, filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+
+	temp := filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".tmp")
+	defer os.Remove(temp)
+	list := new_file_list(task.FileList)
+	bar := new_progress_bar("Archiving "+task_name(task), int64(len(entries)))
+	err = write_tar(temp, entries, list, task.drift, bar)
+	bar.finish()
+	if err != nil {
+		return err
+	}
+	if err := solid_run(task)(temp, target); err != nil {
+		return err
+	}
+	if task.ZstdDictPath != "" {
+		dict, err := filepath.Abs(task.ZstdDictPath)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(target+zstd_dict_suffix, []byte(dict+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+	return list.write(target)
+}
+
+func write_tar(target string, entries []sourceEntry, list *fileList, drift *driftReport, bar *progressBar) error {
+	file, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	archive := tar.NewWriter(file)
+	for _, entry := range entries {
+		if err := run_ctx.Err(); err != nil {
+			return err
+		}
+		link := ""
+		if entry.info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(entry.path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(entry.info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = entry.name
+		if entry.info.IsDir() {
+			header.Name += "/"
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if entry.info.Mode().IsRegular() {
+			if err := write_tar_file(archive, entry, list); err != nil {
+				return err
+			}
+		}
+		bar.add(1)
+	}
+	if drift != nil {
+		// As in a zip, the report of a DriftBaseline task travels with it.
+		report := drift.String()
+		header := &tar.Header{Name: drift_report_name, Mode: 0644, Size: int64(len(report)), ModTime: time.Now()}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(archive, report); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func write_tar_file(archive *tar.Writer, entry sourceEntry, list *fileList) error {
+	file, err := os.Open(entry.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(archive, hash), contextReader{file}); err != nil {
+		return err
+	}
+	list.add(entry.name, entry.info.Size(), hash.Sum(nil))
+	return nil
+}
+
+// is_solid_name reports whether name is a Solid archive goBack can read.
+func is_solid_name(name string) bool {
+	_, ok := solid_reader_for(name)
+	return ok
+}
+
+func solid_reader_for(name string) (compressor, bool) {
+	for _, c := range best_compressors {
+		if strings.HasSuffix(name, ".tar"+c.ext) {
+			return c, true
+		}
+	}
+	return compressor{}, false
+}
+
+// commandReader is the stdout of a decompressing command; Close waits for
+// the command and reports its failure.
+type commandReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *commandReader) Close() error {
+	r.ReadCloser.Close()
+	return r.cmd.Wait()
+}
+
+// open_solid returns the decompressed tar stream of a Solid archive.
+func open_solid(archive string) (io.ReadCloser, error) {
+	c, ok := solid_reader_for(archive)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a solid archive", filepath.Base(archive))
+	}
+	if c.name == "gzip" {
+		file, err := os.Open(archive)
+		if err != nil {
+			return nil, err
+		}
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{reader, file}, nil
+	}
+	args := []string{"-d", "-c"}
+	if dict, err := os.ReadFile(archive + zstd_dict_suffix); err == nil && c.name == "zstd" {
+		args = append(args, "-D", strings.TrimSpace(string(dict)))
+	}
+	cmd := run_command(c.name, append(args, archive)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &commandReader{stdout, cmd}, nil
+}
+
+// extract_solid extracts the entries of a Solid archive matching pattern
+// into dest and returns how many it extracted.
+func extract_solid(archive, dest, pattern string) (int, error) {
+	stream, err := open_solid(archive)
+	if err != nil {
+		return 0, err
+	}
+	reader := tar.NewReader(stream)
+	extracted := 0
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			stream.Close()
+			return extracted, err
+		}
+		if header.Name == drift_report_name || !restore_match(pattern, header.Name) {
+			continue
+		}
+		if err := extract_tar_entry(reader, header, dest); err != nil {
+			stream.Close()
+			return extracted, err
+		}
+		extracted++
+	}
+	return extracted, stream.Close()
+}
+
+func extract_tar_entry(reader *tar.Reader, header *tar.Header, dest string) error {
+	target, err := safe_join(dest, header.Name)
+	if err != nil {
+		return err
+	}
+	if header.Typeflag == tar.TypeDir {
+		return os.MkdirAll(target, 0755)
+	}
+	if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeSymlink {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	// A symlink restored earlier must not redirect this entry outside dest.
+	parent, err := filepath.EvalSymlinks(filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+	root, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
+	if !path_within(parent, root) {
+		return fmt.Errorf("archive entry %q escapes the destination", header.Name)
+	}
+	if header.Typeflag == tar.TypeSymlink {
+		os.Remove(target)
+		return os.Symlink(header.Linkname, target)
+	}
+	// Nor may a symlink at target itself: replace it rather than write
+	// through it.
+	if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, os.FileMode(header.Mode).Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, reader); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(target, header.ModTime, header.ModTime)
+}
+
+// verify_solid reads a Solid archive to the end, decompressing every entry.
+func verify_solid(path string) error {
+	stream, err := open_solid(path)
+	if err != nil {
+		return err
+	}
+	reader := tar.NewReader(stream)
+	for {
+		_, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			_, err = io.Copy(io.Discard, reader)
+		}
+		if err != nil {
+			stream.Close()
+			return err
+		}
+	}
+	return stream.Close()
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSolidArchive(t *testing.T) {
+	tests := []struct {
+		name  string
+		solid string
+		drift *driftReport
+	}{
+		{"gzip", "gzip", nil},
+		{"zstd", "zstd", nil},
+		{"xz", "xz", nil},
+		{"gzip with drift report", "gzip", &driftReport{modified: []string{"conf/app.conf"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.solid != "gzip" {
+				if _, err := exec.LookPath(tt.solid); err != nil {
+					t.Skipf("%s not installed", tt.solid)
+				}
+			}
+			dir := t.TempDir()
+			source := filepath.Join(dir, "conf")
+			os.MkdirAll(filepath.Join(source, "sub"), 0755)
+			os.WriteFile(filepath.Join(source, "app.conf"), []byte("port = 80\n"), 0644)
+			os.WriteFile(filepath.Join(source, "sub", "db.conf"), []byte("host = db\n"), 0644)
+			store := filepath.Join(dir, "store")
+			os.MkdirAll(store, 0755)
+			task := BackupTask{Name: "conf", BackupSource: source, StorePath: store, Solid: tt.solid, drift: tt.drift}
+			target := filepath.Join(store, "conf-20261014-100000"+solid_extension(task))
+
+			if err := createSolidArchive(task, target); err != nil {
+				t.Fatal(err)
+			}
+			if err := verify_solid(target); err != nil {
+				t.Fatalf("verify_solid: %v", err)
+			}
+
+			stream, err := open_solid(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader := tar.NewReader(stream)
+			var report string
+			for {
+				header, err := reader.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if header.Name == drift_report_name {
+					data, _ := io.ReadAll(reader)
+					report = string(data)
+				}
+			}
+			stream.Close()
+			if tt.drift != nil && report != tt.drift.String() {
+				t.Errorf("drift report = %q, want %q", report, tt.drift.String())
+			}
+			if tt.drift == nil && report != "" {
+				t.Errorf("unexpected drift report %q", report)
+			}
+
+			dest := filepath.Join(dir, "restored")
+			os.MkdirAll(dest, 0755)
+			if _, err := extract_solid(target, dest, ""); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(filepath.Join(dest, "conf", "sub", "db.conf"))
+			if err != nil || string(data) != "host = db\n" {
+				t.Errorf("restored db.conf = %q, %v", data, err)
+			}
+			if _, err := os.Stat(filepath.Join(dest, drift_report_name)); err == nil {
+				t.Error("drift report was restored with the files")
+			}
+		})
+	}
+}
+
+// A solid archive of many tiny files is smaller than a zip of them, which
+// compresses each file on its own.
+func TestSolidSmallerThanZip(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "conf")
+	store := filepath.Join(dir, "store")
+	files := map[string]string{}
+	for i := 0; i < 500; i++ {
+		files[fmt.Sprintf("d%d/host%d.conf", i%10, i)] = fmt.Sprintf("host = web%d\nport = 8080\nenabled = true\n", i)
+	}
+	write_tree(t, source, files)
+	os.MkdirAll(store, 0755)
+
+	zip_target := filepath.Join(store, "conf-20261014-100000.zip")
+	if err := createZip(BackupTask{Name: "conf", BackupSource: source, StorePath: store}, zip_target); err != nil {
+		t.Fatal(err)
+	}
+	task := BackupTask{Name: "conf", BackupSource: source, StorePath: store, Solid: "gzip"}
+	solid_target := filepath.Join(store, "conf-20261014-100000"+solid_extension(task))
+	if err := createSolidArchive(task, solid_target); err != nil {
+		t.Fatal(err)
+	}
+	zip_info, _ := os.Stat(zip_target)
+	solid_info, _ := os.Stat(solid_target)
+	if solid_info.Size() >= zip_info.Size() {
+		t.Errorf("solid archive is %d bytes, zip %d", solid_info.Size(), zip_info.Size())
+	}
+}
+
+// write_tar_gz writes a .tar.gz holding the given headers, each regular
+// file with body as its content.
+func write_tar_gz(t *testing.T, path string, headers []*tar.Header, body string) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := gzip.NewWriter(file)
+	writer := tar.NewWriter(compressed)
+	for _, header := range headers {
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(body))
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			io.WriteString(writer, body)
+		}
+	}
+	writer.Close()
+	compressed.Close()
+	file.Close()
+}
+
+// A symlink at an entry's own path, from the archive or already in dest,
+// is replaced rather than written through.
+func TestExtractSolidSymlink(t *testing.T) {
+	tests := []struct {
+		name     string
+		in_tar   bool // the archive carries the link before the file
+		existing bool // dest already holds the link
+	}{
+		{"link in archive", true, false},
+		{"link in dest", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			victim := filepath.Join(dir, "passwd")
+			os.WriteFile(victim, []byte("root:x:0:0\n"), 0644)
+			dest := filepath.Join(dir, "restored")
+			os.MkdirAll(filepath.Join(dest, "conf"), 0755)
+			if tt.existing {
+				os.Symlink(victim, filepath.Join(dest, "conf", "a"))
+			}
+			var headers []*tar.Header
+			if tt.in_tar {
+				headers = append(headers, &tar.Header{Name: "conf/a", Typeflag: tar.TypeSymlink, Linkname: victim})
+			}
+			headers = append(headers, &tar.Header{Name: "conf/a", Typeflag: tar.TypeReg, Mode: 0644})
+			archive := filepath.Join(dir, "conf-20261014-100000.tar.gz")
+			write_tar_gz(t, archive, headers, "pwned\n")
+
+			if _, err := extract_solid(archive, dest, ""); err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := os.ReadFile(victim); string(data) != "root:x:0:0\n" {
+				t.Errorf("file outside dest now holds %q", data)
+			}
+			info, err := os.Lstat(filepath.Join(dest, "conf", "a"))
+			if err != nil || !info.Mode().IsRegular() {
+				t.Fatalf("conf/a = %v, %v; want a regular file", info, err)
+			}
+			if data, _ := os.ReadFile(filepath.Join(dest, "conf", "a")); string(data) != "pwned\n" {
+				t.Errorf("conf/a = %q", data)
+			}
+		})
+	}
+}
+
+func TestZstdDict(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd not installed")
+	}
+	dir := t.TempDir()
+	source := filepath.Join(dir, "conf")
+	store := filepath.Join(dir, "store")
+	write_tree(t, source, map[string]string{"app.conf": "port = 80\nworkers = 4\n"})
+	os.MkdirAll(store, 0755)
+	dict := filepath.Join(dir, "conf.dict")
+	os.WriteFile(dict, []byte(strings.Repeat("port = 80\nworkers = 4\nlisten = 0.0.0.0\n", 64)), 0644)
+
+	tests := []struct {
+		name  string
+		task  BackupTask
+		valid bool
+	}{
+		{"zstd", BackupTask{Solid: "zstd", ZstdDictPath: dict}, true},
+		{"not zstd", BackupTask{Solid: "gzip", ZstdDictPath: dict}, false},
+		{"not solid", BackupTask{ZstdDictPath: dict}, false},
+		{"missing dictionary", BackupTask{Solid: "zstd", ZstdDictPath: filepath.Join(dir, "missing.dict")}, false},
+	}
+	for _, tt := range tests {
+		tt.task.Name = "conf"
+		if err := validate_task(tt.task); (err == nil) != tt.valid {
+			t.Errorf("%s: validate_task = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+
+	// A relative ZstdDictPath is recorded as an absolute one.
+	cwd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(cwd)
+	task := BackupTask{Name: "conf", BackupSource: source, StorePath: store, Solid: "zstd", ZstdDictPath: "conf.dict"}
+	target := filepath.Join(store, "conf-20261014-100000"+solid_extension(task))
+	if err := createSolidArchive(task, target); err != nil {
+		t.Fatal(err)
+	}
+	if recorded, _ := os.ReadFile(target + zstd_dict_suffix); string(recorded) != dict+"\n" {
+		t.Errorf("%s = %q, want %s", zstd_dict_suffix, recorded, dict)
+	}
+	if err := exec.Command("zstd", "-t", "-q", target).Run(); err == nil {
+		t.Error("archive decompresses without its dictionary")
+	}
+	if err := verify_solid(target); err != nil {
+		t.Errorf("verify_solid: %v", err)
+	}
+	dest := filepath.Join(dir, "restored")
+	if err := restore_backup(target, dest, ""); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "conf", "app.conf")); string(data) != "port = 80\nworkers = 4\n" {
+		t.Errorf("restored app.conf = %q", data)
+	}
+
+	// The dictionary pays off on input that resembles it.
+	plain_task := BackupTask{Name: "conf", BackupSource: source, StorePath: store, Solid: "zstd"}
+	plain := filepath.Join(store, "conf-20261014-110000"+solid_extension(plain_task))
+	if err := createSolidArchive(plain_task, plain); err != nil {
+		t.Fatal(err)
+	}
+	with_dict, _ := os.Stat(target)
+	without, _ := os.Stat(plain)
+	if with_dict.Size() >= without.Size() {
+		t.Errorf("with the dictionary %d bytes, without %d", with_dict.Size(), without.Size())
+	}
+}
+
+func TestSolidLevel(t *testing.T) {
+	level := func(n int) *int { return &n }
+	tests := []struct {
+		name  string
+		task  BackupTask
+		valid bool
+	}{
+		{"default level", BackupTask{Solid: "gzip"}, true},
+		{"gzip 1", BackupTask{Solid: "gzip", SolidLevel: level(1)}, true},
+		{"gzip 0", BackupTask{Solid: "gzip", SolidLevel: level(0)}, false},
+		{"xz 0", BackupTask{Solid: "xz", SolidLevel: level(0)}, true},
+		{"zstd 19", BackupTask{Solid: "zstd", SolidLevel: level(19)}, true},
+		{"zstd 20", BackupTask{Solid: "zstd", SolidLevel: level(20)}, false},
+		{"without Solid", BackupTask{SolidLevel: level(5)}, false},
+	}
+	for _, tt := range tests {
+		if _, err := exec.LookPath(tt.task.Solid); tt.task.Solid != "" && tt.task.Solid != "gzip" && err != nil {
+			continue
+		}
+		tt.task.Name = "conf"
+		if err := validate_task(tt.task); (err == nil) != tt.valid {
+			t.Errorf("%s: validate_task = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+
+	// zstd and xz are refused up front when they are not installed.
+	t.Setenv("PATH", t.TempDir())
+	for solid, valid := range map[string]bool{"gzip": true, "zstd": false, "xz": false} {
+		if err := validate_task(BackupTask{Name: "conf", Solid: solid}); (err == nil) != valid {
+			t.Errorf("Solid %s without its command: validate_task = %v, want valid %v", solid, err, valid)
+		}
+	}
+}
+
+func TestSolidLevelSize(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "conf")
+	store := filepath.Join(dir, "store")
+	var lines []string
+	for i := 0; i < 5000; i++ {
+		lines = append(lines, fmt.Sprintf("option_%d = %d", i%97, i*i%1013))
+	}
+	write_tree(t, source, map[string]string{"app.conf": strings.Join(lines, "\n")})
+	os.MkdirAll(store, 0755)
+
+	sizes := map[int]int64{}
+	for _, n := range []int{1, 9} {
+		level := n
+		task := BackupTask{Name: "conf", BackupSource: source, StorePath: store, Solid: "gzip", SolidLevel: &level}
+		target := filepath.Join(store, fmt.Sprintf("conf-20261014-10000%d.tar.gz", n))
+		if err := createSolidArchive(task, target); err != nil {
+			t.Fatal(err)
+		}
+		if err := verify_solid(target); err != nil {
+			t.Fatalf("level %d: verify_solid: %v", n, err)
+		}
+		info, _ := os.Stat(target)
+		sizes[n] = info.Size()
+	}
+	if sizes[9] >= sizes[1] {
+		t.Errorf("level 9 wrote %d bytes, level 1 %d", sizes[9], sizes[1])
+	}
+
+	// xz compresses the same input tighter than gzip.
+	if _, err := exec.LookPath("xz"); err != nil {
+		t.Skip("xz not installed")
+	}
+	task := BackupTask{Name: "conf", BackupSource: source, StorePath: store, Solid: "xz"}
+	target := filepath.Join(store, "conf-20261014-110000"+solid_extension(task))
+	if err := createSolidArchive(task, target); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "restored")
+	if _, err := extract_solid(target, dest, ""); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "conf", "app.conf")); string(data) != strings.Join(lines, "\n") {
+		t.Error("tar.xz did not restore app.conf")
+	}
+	if info, _ := os.Stat(target); info.Size() >= sizes[9] {
+		t.Errorf("xz wrote %d bytes, gzip -9 %d", info.Size(), sizes[9])
+	}
+}
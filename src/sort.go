@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sort_keys are the accepted SortBy values. Each returns whether file a goes
+// before file b; ties fall back to the walk order.
+var sort_keys = map[string]func(a, b sourceEntry) bool{
+	"name": func(a, b sourceEntry) bool {
+		return filepath.Base(a.path) < filepath.Base(b.path)
+	},
+	"size": func(a, b sourceEntry) bool {
+		return a.info.Size() < b.info.Size()
+	},
+	"extension": func(a, b sourceEntry) bool {
+		return strings.ToLower(filepath.Ext(a.path)) < strings.ToLower(filepath.Ext(b.path))
+	},
+}
+
+func valid_sort_by(by string) error {
+	if _, ok := sort_keys[by]; by != "" && !ok {
+		return fmt.Errorf("invalid SortBy %q: want name, size or extension", by)
+	}
+	return nil
+}
+
+// sort_source reorders the files of entries by the SortBy key. Directories
+// keep their walk order and come first, so every directory entry still
+// precedes the files stored under it.
+func sort_source(entries []sourceEntry, by string) {
+	less, ok := sort_keys[by]
+	if !ok {
+		return
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.info.IsDir() || b.info.IsDir() {
+			return a.info.IsDir() && !b.info.IsDir()
+		}
+		return less(a, b)
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSortSource(t *testing.T) {
+	tests := []struct {
+		by   string
+		want string
+	}{
+		{"", "site,site/b.txt,site/c.md,site/sub,site/sub/a.go"},
+		{"name", "site,site/sub,site/sub/a.go,site/b.txt,site/c.md"},
+		{"size", "site,site/sub,site/c.md,site/b.txt,site/sub/a.go"},
+		{"extension", "site,site/sub,site/sub/a.go,site/c.md,site/b.txt"},
+	}
+	dir := t.TempDir()
+	source := filepath.Join(dir, "site")
+	os.MkdirAll(filepath.Join(source, "sub"), 0755)
+	os.WriteFile(filepath.Join(source, "b.txt"), []byte("bbb"), 0644)
+	os.WriteFile(filepath.Join(source, "c.md"), []byte("c"), 0644)
+	os.WriteFile(filepath.Join(source, "sub", "a.go"), []byte("package a\n"), 0644)
+	for _, tt := range tests {
+		t.Run(tt.by, func(t *testing.T) {
+			entries, err := collect_source(source, filepath.Join(dir, "store"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort_source(entries, tt.by)
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.name)
+			}
+			if got := strings.Join(names, ","); got != tt.want {
+				t.Errorf("order = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortByArchives(t *testing.T) {
+	tests := []struct {
+		name string
+		task BackupTask
+	}{
+		{"plain", BackupTask{}},
+		{"parallel", BackupTask{ArchiveWorkers: 2}},
+		{"delta", BackupTask{DeltaMode: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, "site")
+			store := filepath.Join(dir, "store")
+			os.MkdirAll(source, 0755)
+			os.MkdirAll(store, 0755)
+			os.WriteFile(filepath.Join(source, "a.txt"), []byte("aaaa"), 0644)
+			os.WriteFile(filepath.Join(source, "b.txt"), []byte("b"), 0644)
+
+			task := tt.task
+			task.Website, task.BackupSource, task.StorePath, task.SortBy = "site", source, store, "size"
+			files, err := archive_source(task, filepath.Join(store, "site-20261014-100000.zip"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader, err := zip.OpenReader(files[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			var names []string
+			for _, file := range reader.File {
+				names = append(names, file.Name)
+			}
+			want := "site/,site/b.txt,site/a.txt"
+			if got := strings.Join(names, ","); got != want {
+				t.Errorf("archive order = %s, want %s", got, want)
+			}
+		})
+	}
+}
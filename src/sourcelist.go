@@ -0,0 +1,94 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// read_source_list returns the paths listed in list_file, one per line.
+// Blank lines and lines starting with # are ignored, and relative paths are
+// resolved against base.
+func read_source_list(list_file, base string) ([]string, error) {
+	file, err := os.Open(list_file)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var paths []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) && base != "" {
+			line = filepath.Join(base, line)
+		}
+		paths = append(paths, filepath.Clean(line))
+	}
+	return paths, scanner.Err()
+}
+
+// source_list_entry_name names a listed path inside the archive the same way
+// createZip would when it sits under source, and by its full path otherwise.
+func source_list_entry_name(source, path string) string {
+	if source != "" {
+		source = filepath.Clean(source)
+		if rel, err := filepath.Rel(source, path); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return filepath.Join(filepath.Base(source), rel)
+		}
+	}
+	return strings.TrimPrefix(path, "/")
+}
+
+// createZipFromList archives exactly the paths named in list_file. Listed
+// directories get an entry of their own but are not walked.
+func createZipFromList(task BackupTask, target string) error {
+	source := task.BackupSource
+	paths, err := read_source_list(task.SourceListFile, source)
+	if err != nil {
+		return err
+	}
+
+	zipfile, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer zipfile.Close()
+
+	archive := zip.NewWriter(zipfile)
+	defer archive.Close()
+
+	list := new_file_list(task.FileList)
+	store_path := filepath.Dir(target)
+	var open map[string]bool
+	if task.SkipOpenFiles {
+		open = files_open_for_writing()
+	}
+	for _, path := range paths {
+		if path_within(path, store_path) {
+			continue
+		}
+		if is_open(open, path) {
+			log.Printf("Skipping %s in %s: open for writing by another process", path, task_name(task))
+			continue
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		name := source_list_entry_name(source, path)
+		if info.IsDir() {
+			name = strings.TrimSuffix(name, "/")
+		}
+		if err := add_zip_entry_named(archive, name, path, info, list); err != nil {
+			return err
+		}
+	}
+	return finish_zip(archive, task, target, list)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadSourceList(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		base string
+		want []string
+	}{
+		{"relative paths", "index.html\ncss/site.css\n", "/srv/site", []string{"/srv/site/index.html", "/srv/site/css/site.css"}},
+		{"comments and blanks", "# static\n\n  index.html  \n", "/srv/site", []string{"/srv/site/index.html"}},
+		{"absolute paths", "/etc/nginx/nginx.conf\n/etc/hosts/\n", "/srv/site", []string{"/etc/nginx/nginx.conf", "/etc/hosts"}},
+		{"no base", "a/../b.txt\n", "", []string{"b.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list := filepath.Join(t.TempDir(), "list.txt")
+			os.WriteFile(list, []byte(tt.list), 0644)
+			got, err := read_source_list(list, tt.base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("paths = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceListEntryName(t *testing.T) {
+	tests := []struct {
+		source string
+		path   string
+		want   string
+	}{
+		{"/srv/site", "/srv/site/css/site.css", "site/css/site.css"},
+		{"/srv/site/", "/srv/site/index.html", "site/index.html"},
+		{"/srv/site", "/srv/site-old/index.html", "srv/site-old/index.html"},
+		{"/srv/site", "/etc/nginx/nginx.conf", "etc/nginx/nginx.conf"},
+		{"", "/etc/hosts", "etc/hosts"},
+	}
+	for _, tt := range tests {
+		if got := source_list_entry_name(tt.source, tt.path); got != tt.want {
+			t.Errorf("source_list_entry_name(%q, %q) = %q, want %q", tt.source, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCreateZipFromList(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "site")
+	store := filepath.Join(source, "backups")
+	os.MkdirAll(filepath.Join(source, "css"), 0755)
+	os.MkdirAll(filepath.Join(source, "uploads"), 0755)
+	os.MkdirAll(store, 0755)
+	os.WriteFile(filepath.Join(source, "index.html"), []byte("<html>"), 0644)
+	os.WriteFile(filepath.Join(source, "css", "site.css"), []byte("body {}"), 0644)
+	os.WriteFile(filepath.Join(source, "uploads", "big.bin"), []byte("data"), 0644)
+	list := filepath.Join(dir, "list.txt")
+	// The directory is stored but not walked, and StorePath is never archived.
+	os.WriteFile(list, []byte("index.html\ncss/site.css\nuploads\nbackups\n"), 0644)
+
+	task := BackupTask{Website: "site", BackupSource: source, StorePath: store, SourceListFile: list}
+	files, err := archive_source(task, filepath.Join(store, "site-20261014-100000.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "site/css/site.css,site/index.html,site/uploads/"
+	if got := strings.Join(zip_names(t, files[0]), ","); got != want {
+		t.Errorf("archive holds %s, want %s", got, want)
+	}
+
+	os.WriteFile(list, []byte("missing.html\n"), 0644)
+	if _, err := archive_source(task, filepath.Join(store, "site-20261014-110000.zip")); err == nil {
+		t.Error("a listed path that does not exist did not fail the backup")
+	}
+}
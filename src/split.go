@@ -0,0 +1,164 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// packUnit is a run of walk paths that should be stored in the same part.
+type packUnit struct {
+	paths []string
+	size  int64
+}
+
+// split_units walks dir and returns the units to pack. A subtree that fits
+// under limit is returned as a single unit so it is never spread over parts;
+// otherwise its children are returned individually. Anything under exclude
+// is skipped.
+func split_units(dir, exclude string, limit int64) ([]packUnit, int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	units := []packUnit{{paths: []string{dir}}}
+	var total int64
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if path_within(path, exclude) {
+			continue
+		}
+		if entry.IsDir() {
+			children, size, err := split_units(path, exclude, limit)
+			if err != nil {
+				return nil, 0, err
+			}
+			units = append(units, children...)
+			total += size
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, 0, err
+		}
+		units = append(units, packUnit{paths: []string{path}, size: info.Size()})
+		total += info.Size()
+	}
+
+	if total <= limit {
+		merged := packUnit{size: total}
+		for _, unit := range units {
+			merged.paths = append(merged.paths, unit.paths...)
+		}
+		return []packUnit{merged}, total, nil
+	}
+	return units, total, nil
+}
+
+func split_part_name(target, ext string, index int) string {
+	return fmt.Sprintf("%s.part%03d%s", strings.TrimSuffix(target, ext), index, ext)
+}
+
+// createSplitZip archives source into numbered parts next to target, each
+// holding at most limit bytes of file data unless a single file is larger.
+// Files are never split, and every part carries the parent directories of
+// the files it holds so each part extracts on its own.
+func createSplitZip(task BackupTask, target string) ([]string, error) {
+	source := filepath.Clean(task.BackupSource)
+	var open map[string]bool
+	if task.SkipOpenFiles {
+		open = files_open_for_writing()
+	}
+	if task.Checkpoint {
+		if checkpoint := load_checkpoint(task); checkpoint != nil {
+			log.Printf("Resuming %s from its checkpoint: %d of %d parts of %s already done", task_name(task), checkpoint.Done, len(checkpoint.Parts), filepath.Base(checkpoint.Target))
+			return write_checkpointed_parts(task, source, checkpoint, open)
+		}
+	}
+
+	limit := task.SplitBySize
+	units, _, err := split_units(source, filepath.Dir(target), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var parts [][]string
+	var current []string
+	var size int64
+	for _, unit := range units {
+		if len(current) > 0 && size > 0 && size+unit.size > limit {
+			parts = append(parts, current)
+			current, size = nil, 0
+		}
+		current = append(current, unit.paths...)
+		size += unit.size
+	}
+	if len(current) > 0 {
+		parts = append(parts, current)
+	}
+
+	if task.Checkpoint {
+		return write_checkpointed_parts(task, source, &splitCheckpoint{Target: target, Parts: parts}, open)
+	}
+	var files []string
+	for i, paths := range parts {
+		part := split_part_name(target, archive_extension(task), i+1)
+		files = append(files, part)
+		if err := write_zip_part(task, source, part, paths, open); err != nil {
+			return files, err
+		}
+	}
+	return files, nil
+}
+
+func write_zip_part(task BackupTask, source, target string, paths []string, open map[string]bool) error {
+	zipfile, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer zipfile.Close()
+
+	archive := zip.NewWriter(zipfile)
+	defer archive.Close()
+
+	list := new_file_list(task.FileList)
+	written := map[string]bool{}
+	var add func(path string) error
+	add = func(path string) error {
+		if written[path] {
+			return nil
+		}
+		if path != source {
+			if err := add(filepath.Dir(path)); err != nil {
+				return err
+			}
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		written[path] = true
+		return add_zip_entry(archive, source, path, info, list)
+	}
+
+	for _, path := range paths {
+		if task.OmitEmptyDirs {
+			// Directories holding files are added as their parents.
+			if info, err := os.Lstat(path); err == nil && info.IsDir() {
+				continue
+			}
+		}
+		if is_open(open, path) {
+			log.Printf("Skipping %s in %s: open for writing by another process", path, task_name(task))
+			continue
+		}
+		if err := add(path); err != nil {
+			return err
+		}
+	}
+	return finish_zip(archive, task, target, list)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// write_split_source creates a tree of four 400-byte files, two of them in
+// a subtree small enough to stay in one part.
+func write_split_source(t *testing.T, dir string) string {
+	t.Helper()
+	source := filepath.Join(dir, "site")
+	for _, name := range []string{"a/1.html", "a/2.html", "b/3.html", "c.txt"} {
+		path := filepath.Join(source, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(strings.Repeat("x", 400)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return source
+}
+
+func TestCreateSplitZip(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int64
+		parts [][]string // the files of each part
+	}{
+		{"fits in one part", 1 << 20, [][]string{{"site/a/1.html", "site/a/2.html", "site/b/3.html", "site/c.txt"}}},
+		{"subtree kept together", 1000, [][]string{{"site/a/1.html", "site/a/2.html"}, {"site/b/3.html", "site/c.txt"}}},
+		{"file larger than the limit", 100, [][]string{{"site/a/1.html"}, {"site/a/2.html"}, {"site/b/3.html"}, {"site/c.txt"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := write_split_source(t, dir)
+			store := filepath.Join(dir, "store")
+			os.MkdirAll(store, 0755)
+			task := BackupTask{Website: "site", BackupSource: source, StorePath: store, SplitBySize: tt.limit}
+
+			files, err := archive_source(task, filepath.Join(store, "site-20261014-100000.zip"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != len(tt.parts) {
+				t.Fatalf("wrote %d parts, want %d: %v", len(files), len(tt.parts), files)
+			}
+			for i, part := range files {
+				if want := filepath.Join(store, split_part_name("site-20261014-100000.zip", ".zip", i+1)); part != want {
+					t.Errorf("part %d is %s, want %s", i+1, part, want)
+				}
+				reader, err := zip.OpenReader(part)
+				if err != nil {
+					t.Fatal(err)
+				}
+				entries := map[string]bool{}
+				var stored []string
+				for _, file := range reader.File {
+					entries[file.Name] = true
+					if !strings.HasSuffix(file.Name, "/") {
+						stored = append(stored, file.Name)
+						rc, err := file.Open()
+						if err != nil {
+							t.Fatal(err)
+						}
+						data, err := io.ReadAll(rc)
+						rc.Close()
+						if err != nil || len(data) != 400 {
+							t.Errorf("%s in part %d: read %d bytes, %v; want 400", file.Name, i+1, len(data), err)
+						}
+					}
+				}
+				reader.Close()
+				sort.Strings(stored)
+				if strings.Join(stored, ",") != strings.Join(tt.parts[i], ",") {
+					t.Errorf("part %d holds %v, want %v", i+1, stored, tt.parts[i])
+				}
+				// Each part extracts on its own, so it carries its files' directories.
+				for _, name := range stored {
+					for d := filepath.Dir(name); d != "."; d = filepath.Dir(d) {
+						if !entries[d+"/"] {
+							t.Errorf("part %d lacks directory %s/ for %s", i+1, d, name)
+						}
+					}
+				}
+			}
+		})
+	}
+}
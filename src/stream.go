@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dumpPipe is a running dump, optionally piped through a filter command.
+// Each stage runs as its own process so a failing dump is not hidden by the
+// exit status of the filter after it.
+type dumpPipe struct {
+	stages []*exec.Cmd
+	stderr []*bytes.Buffer
+	output io.ReadCloser
+}
+
+// start_dump starts `sh -c command`, feeding it through `sh -c filter`
+// when filter is set. The caller reads output and then calls wait.
+func start_dump(command, filter string) (*dumpPipe, error) {
+	pipe := &dumpPipe{}
+	commands := []string{command}
+	if filter != "" {
+		commands = append(commands, filter)
+	}
+
+	var input io.Reader
+	for _, c := range commands {
+		cmd := run_command("sh", "-c", c)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if input != nil {
+			cmd.Stdin = input
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			pipe.kill()
+			return nil, err
+		}
+		pipe.stages = append(pipe.stages, cmd)
+		pipe.stderr = append(pipe.stderr, &stderr)
+		input = stdout
+		pipe.output = stdout
+	}
+	return pipe, nil
+}
+
+// wait waits for every stage and returns the first failure.
+func (p *dumpPipe) wait() error {
+	var first error
+	for i, cmd := range p.stages {
+		if err := cmd.Wait(); err != nil && first == nil {
+			first = fmt.Errorf("%s: %v: %s", cmd.Args[2], err, strings.TrimSpace(p.stderr[i].String()))
+		}
+	}
+	return first
+}
+
+// record adds the pipeline, how it ended and what its stages wrote to
+// stderr to runlog.
+func (p *dumpPipe) record(runlog *runLog, start time.Time, err error) {
+	var cmdlines, stderr []string
+	for i, cmd := range p.stages {
+		cmdlines = append(cmdlines, cmd.Args[2])
+		stderr = append(stderr, p.stderr[i].String())
+	}
+	runlog.command(strings.Join(cmdlines, " | "), start, err, strings.Join(stderr, "\n"))
+}
+
+// kill stops every stage, e.g. once the consumer of the output gave up and
+// the stages would block on a full pipe.
+func (p *dumpPipe) kill() {
+	for _, cmd := range p.stages {
+		cmd.Process.Kill()
+	}
+	for _, cmd := range p.stages {
+		cmd.Wait()
+	}
+}
+
+// dump_to_file runs the dump pipeline into target.
+func dump_to_file(task BackupTask, command, target string) error {
+	file, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	start := time.Now()
+	pipe, err := start_dump(command, task.FilterCmd)
+	if err != nil {
+		return err
+	}
+	written, err := io.Copy(file, throttle(task, pipe.output))
+	if err != nil {
+		pipe.kill()
+		pipe.record(task.runlog, start, err)
+		return err
+	}
+	err = pipe.wait()
+	pipe.record(task.runlog, start, err)
+	if err != nil {
+		return err
+	}
+	task.runlog.printf("Wrote %s (%s)", filepath.Base(target), format_bytes(uint64(written)))
+	return file.Close()
+}
+
+// stream_to_rclone pipes the dump into `rclone rcat remote`, so it never
+// touches local disk.
+func stream_to_rclone(task BackupTask, command, remote string) error {
+	pipe, err := start_dump(command, task.FilterCmd)
+	if err != nil {
+		return err
+	}
+	var rcat_output bytes.Buffer
+	args := []string{"rcat", remote}
+	if task.RemoteRetentionTag != "" {
+		args = append(args, "--header-upload", "X-Amz-Tagging: "+task.RemoteRetentionTag)
+	}
+	rcat := run_command(binary(task.RclonePath, "rclone"), args...)
+	rcat.Stdin = with_progress(task, throttle(task, pipe.output), 0, remote)
+	rcat.Stderr = &rcat_output
+
+	start := time.Now()
+	if err := rcat.Run(); err != nil {
+		pipe.kill()
+		task.runlog.command(strings.Join(rcat.Args, " "), start, err, rcat_output.String())
+		return fmt.Errorf("rclone rcat: %v: %s", err, strings.TrimSpace(rcat_output.String()))
+	}
+	err = pipe.wait()
+	pipe.record(task.runlog, start, err)
+	task.runlog.command(strings.Join(rcat.Args, " "), start, nil, rcat_output.String())
+	if err != nil {
+		// rcat has stored what the dump wrote before failing; left on the
+		// remote, the truncated dump would pass for a backup.
+		if output, delete_err := run_command(binary(task.RclonePath, "rclone"), "deletefile", remote).CombinedOutput(); delete_err != nil {
+			return fmt.Errorf("%v; removing the truncated %s also failed: %v: %s", err, remote, delete_err, strings.TrimSpace(string(output)))
+		}
+	}
+	return err
+}
+
+// prune_remote keeps the newest MaxBackup of the task's files on its
+// remote. Streamed backups have no local copy, so the usual sync cannot
+// rotate them.
+func prune_remote(task BackupTask) error {
+	if safe_mode {
+		return nil
+	}
+	if task.RemoteRetentionTag != "" {
+		// Expiry is left to the bucket's lifecycle rules.
+		return nil
+	}
+	remote, err := list_remote(task)
+	if err != nil {
+		return err
+	}
+	pattern := task_file_pattern(task)
+	var names []string
+	for _, name := range remote {
+		if pattern.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	// Names end in a sortable timestamp, so lexical order is creation order.
+	sort.Strings(names)
+	for i := 0; i < len(names)-task.MaxBackup; i++ {
+		if err := run_command(binary(task.RclonePath, "rclone"), "deletefile", task.RemotePath+"/"+names[i]).Run(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fake_rclone writes an rclone stand-in into dir that keeps rcat, copyto,
+// sync and copy uploads and deletes in dir/remote, for remotes such as
+// "r:x/name". sync and copy honour a single --exclude.
+func fake_rclone(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "rclone")
+	script := `#!/bin/sh
+remote="` + filepath.Join(dir, "remote") + `"
+mkdir -p "$remote"
+case "$1" in
+rcat) cat > "$remote/$(basename "$2")";;
+deletefile) rm "$remote/$(basename "$2")";;
+copyto) cp "$2" "$remote/$(basename "$3")";;
+lsf) ls "$remote";;
+sync|copy)
+	source="$2"
+	exclude=
+	while [ $# -gt 0 ]; do
+		[ "$1" = --exclude ] && exclude="$2"
+		shift
+	done
+	for file in "$source"/* "$source"/.[!.]* "$source"/..?*; do
+		[ -f "$file" ] || continue
+		name=$(basename "$file")
+		case "$name" in $exclude) continue;; esac
+		cp "$file" "$remote/$name"
+	done;;
+esac
+`
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStreamToRclone(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		filter  string
+		fails   bool
+	}{
+		{"dump succeeds", "echo 'CREATE TABLE t (id int);'", "", false},
+		{"dump fails after writing", "echo 'CREATE TABLE t'; exit 3", "", true},
+		{"filter fails", "echo 'CREATE TABLE t (id int);'", "cat; exit 4", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			task := BackupTask{Database: "shop", RclonePath: fake_rclone(t, dir), FilterCmd: tt.filter}
+			err := stream_to_rclone(task, tt.command, "r:x/shop-20261014-100000.sql")
+			if (err != nil) != tt.fails {
+				t.Fatalf("err = %v, want failure %v", err, tt.fails)
+			}
+			_, stat_err := os.Stat(filepath.Join(dir, "remote", "shop-20261014-100000.sql"))
+			if tt.fails && stat_err == nil {
+				t.Error("truncated dump left on the remote")
+			}
+			if !tt.fails && stat_err != nil {
+				t.Errorf("dump missing on the remote: %v", stat_err)
+			}
+		})
+	}
+}
+
+func TestDumpFilter(t *testing.T) {
+	dump := "printf 'CREATE TABLE users (id int);\\nINSERT INTO users VALUES (1);\\n'"
+	tests := []struct {
+		name    string
+		command string
+		filter  string
+		want    string
+		fails   bool
+	}{
+		{"no filter", dump, "", "CREATE TABLE users (id int);\nINSERT INTO users VALUES (1);\n", false},
+		{"schema filter", dump, "grep -v '^INSERT'", "CREATE TABLE users (id int);\n", false},
+		{"rewriting filter", dump, "sed 's/users/accounts/'", "CREATE TABLE accounts (id int);\nINSERT INTO accounts VALUES (1);\n", false},
+		{"filter fails", dump, "cat; exit 5", "", true},
+		{"dump fails", dump + "; exit 2", "cat", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "shop.sql")
+			err := dump_to_file(BackupTask{Database: "shop", FilterCmd: tt.filter}, tt.command, target)
+			if (err != nil) != tt.fails {
+				t.Fatalf("err = %v, want failure %v", err, tt.fails)
+			}
+			if tt.fails {
+				return
+			}
+			if data, _ := os.ReadFile(target); string(data) != tt.want {
+				t.Errorf("dump = %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dbTable is a table or view as listed by information_schema.
+type dbTable struct {
+	name string
+	view bool
+	size int64
+}
+
+// list_tables returns the tables and views of the task's database with the
+// size of their data and indexes.
+func list_tables(task BackupTask) ([]dbTable, error) {
+	query := "SELECT TABLE_NAME, TABLE_TYPE, IFNULL(DATA_LENGTH, 0) + IFNULL(INDEX_LENGTH, 0) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE()"
+	output, err := run_command(binary(task.MysqlPath, "mysql"), "-N", "-B", task.Database, "-e", query).Output()
+	if err != nil {
+		return nil, err
+	}
+	var tables []dbTable
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[2], 10, 64)
+		tables = append(tables, dbTable{name: fields[0], view: fields[1] == "VIEW", size: size})
+	}
+	return tables, nil
+}
+
+// table_groups spreads the tables over at most workers groups of about the
+// same size, largest tables first. Views come last in a group of their own,
+// as restoring a view needs every table it refers to.
+func table_groups(tables []dbTable, workers int) [][]string {
+	sort.SliceStable(tables, func(i, j int) bool { return tables[i].size > tables[j].size })
+	groups := make([][]string, workers)
+	sizes := make([]int64, workers)
+	var views []string
+	for _, table := range tables {
+		if table.view {
+			views = append(views, table.name)
+			continue
+		}
+		smallest := 0
+		for w := range sizes {
+			if sizes[w] < sizes[smallest] {
+				smallest = w
+			}
+		}
+		groups[smallest] = append(groups[smallest], table.name)
+		sizes[smallest] += table.size
+	}
+	var result [][]string
+	for _, group := range groups {
+		if len(group) > 0 {
+			sort.Strings(group)
+			result = append(result, group)
+		}
+	}
+	if len(views) > 0 {
+		sort.Strings(views)
+		result = append(result, views)
+	}
+	return result
+}
+
+// readLock holds FLUSH TABLES WITH READ LOCK in a mysql session until
+// release is called, so no write lands while the dumps take their
+// snapshots.
+type readLock struct {
+	stdin io.WriteCloser
+	wait  func() error
+}
+
+func lock_tables(task BackupTask) (*readLock, error) {
+	cmd := run_command(binary(task.MysqlPath, "mysql"), "-N", "-B", task.Database)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	lock := &readLock{stdin: stdin, wait: cmd.Wait}
+	if _, err := io.WriteString(stdin, "FLUSH TABLES WITH READ LOCK;\nSELECT 'goBack-locked';\n"); err != nil {
+		lock.release()
+		return nil, err
+	}
+	// The SELECT only answers once the lock is held.
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if scanner.Text() == "goBack-locked" {
+			go io.Copy(io.Discard, stdout)
+			return lock, nil
+		}
+	}
+	err = lock.release()
+	if err == nil {
+		err = fmt.Errorf("mysql exited before taking the read lock")
+	}
+	return nil, err
+}
+
+func (l *readLock) release() error {
+	io.WriteString(l.stdin, "UNLOCK TABLES;\n")
+	l.stdin.Close()
+	return l.wait()
+}
+
+// dump_tables dumps the task's database with ParallelTables mysqldumps at
+// once, each taking a share of the tables, into target. Every dump runs
+// in its own --single-transaction. The read lock is held until each of
+// them has started, so their snapshots are all of the same moment; writes
+// are only blocked for that long.
+func dump_tables(task BackupTask, command, target string) error {
+	tables, err := list_tables(task)
+	if err != nil {
+		return fmt.Errorf("listing tables: %v", err)
+	}
+	if len(tables) == 0 {
+		return dump_to_file(task, command, target)
+	}
+	groups := table_groups(tables, task.ParallelTables)
+
+	parts := make([]string, len(groups))
+	for i := range groups {
+		parts[i] = filepath.Join(filepath.Dir(target), fmt.Sprintf(".%s.t%03d.tmp", filepath.Base(target), i))
+	}
+	defer func() {
+		for _, part := range parts {
+			os.Remove(part)
+		}
+	}()
+
+	lock, err := lock_tables(task)
+	if err != nil {
+		return fmt.Errorf("taking read lock: %v", err)
+	}
+	var started, done sync.WaitGroup
+	errs := make([]error, len(groups))
+	for i, group := range groups {
+		part_command := command + " --single-transaction"
+		for _, table := range group {
+			part_command += " " + shell_quote(table)
+		}
+		started.Add(1)
+		done.Add(1)
+		go func(i int) {
+			defer done.Done()
+			errs[i] = dump_part(task, part_command, parts[i], started.Done)
+		}(i)
+	}
+	started.Wait()
+	if err := lock.release(); err != nil {
+		log.Printf("Error releasing read lock on %s: %v", task.Database, err)
+	}
+	log.Printf("Dumping %s: %d tables in %d parallel dumps", task.Database, len(tables), len(groups))
+	done.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return concat_files(parts, target)
+}
+
+// dump_part runs one dump into target and calls started once it has begun
+// dumping. mysqldump opens its transaction right after writing its
+// header, and the header stays in its output buffer, so the first bytes to
+// arrive come after the snapshot was taken.
+func dump_part(task BackupTask, command, target string, started func()) error {
+	var once sync.Once
+	defer once.Do(started)
+
+	file, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	start := time.Now()
+	pipe, err := start_dump(command, task.FilterCmd)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := pipe.output.Read(buf)
+		if n > 0 {
+			once.Do(started)
+			if _, err := file.Write(buf[:n]); err != nil {
+				pipe.kill()
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			pipe.kill()
+			return err
+		}
+	}
+	err = pipe.wait()
+	pipe.record(task.runlog, start, err)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// concat_files writes parts one after another into target.
+func concat_files(parts []string, target string) error {
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	for _, part := range parts {
+		file, err := os.Open(part)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, contextReader{file})
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTableGroups(t *testing.T) {
+	tables := []dbTable{
+		{"orders", false, 900}, {"customers", false, 500}, {"products", false, 400},
+		{"sessions", false, 100}, {"settings", false, 1}, {"order_totals", true, 0},
+	}
+	tests := []struct {
+		workers int
+		want    string
+	}{
+		{1, "[customers orders products sessions settings] [order_totals]"},
+		{2, "[orders sessions] [customers products settings] [order_totals]"},
+		{3, "[orders] [customers settings] [products sessions] [order_totals]"},
+		{8, "[orders] [customers] [products] [sessions] [settings] [order_totals]"},
+	}
+	for _, tt := range tests {
+		groups := table_groups(append([]dbTable(nil), tables...), tt.workers)
+		var got []string
+		for _, group := range groups {
+			got = append(got, fmt.Sprint(group))
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("%d workers: groups %s, want %s", tt.workers, strings.Join(got, " "), tt.want)
+		}
+	}
+}
+
+func TestDumpTables(t *testing.T) {
+	dir := t.TempDir()
+	mysql := filepath.Join(dir, "mysql")
+	client := `#!/bin/sh
+case "$*" in
+*information_schema*) printf 'orders\tBASE TABLE\t900\ncustomers\tBASE TABLE\t500\nsessions\tBASE TABLE\t100\norder_totals\tVIEW\t0\n';;
+*) while read line; do
+	echo "$line" >> "` + filepath.Join(dir, "lock.log") + `"
+	case "$line" in "SELECT 'goBack-locked';") echo goBack-locked;; esac
+done;;
+esac
+`
+	mysqldump := filepath.Join(dir, "mysqldump")
+	dump := `#!/bin/sh
+echo "$@" >> "` + filepath.Join(dir, "mysqldump.args") + `"
+shift 2
+for table; do echo "CREATE TABLE $table;"; done
+`
+	os.WriteFile(mysql, []byte(client), 0755)
+	os.WriteFile(mysqldump, []byte(dump), 0755)
+
+	task := BackupTask{Database: "shop", StorePath: dir, MysqlPath: mysql, MysqldumpPath: mysqldump, ParallelTables: 2}
+	files, err := backup_database(task, "", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(files[0])
+	want := "CREATE TABLE orders;\nCREATE TABLE customers;\nCREATE TABLE sessions;\nCREATE TABLE order_totals;\n"
+	if string(data) != want {
+		t.Errorf("dump = %q, want %q", data, want)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "mysqldump.args"))
+	if got := strings.Count(string(args), "shop --single-transaction"); got != 3 {
+		t.Errorf("mysqldump ran with %q, want 3 --single-transaction dumps", args)
+	}
+	lock, _ := os.ReadFile(filepath.Join(dir, "lock.log"))
+	if got := string(lock); got != "FLUSH TABLES WITH READ LOCK;\nSELECT 'goBack-locked';\nUNLOCK TABLES;\n" {
+		t.Errorf("lock session ran %q", got)
+	}
+	for _, name := range remaining(dir) {
+		if strings.HasSuffix(name, ".tmp") {
+			t.Errorf("left part %s behind", name)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"log"
+	"time"
+)
+
+// progressReader counts the bytes read through it and reports them every
+// interval, so a long upload shows how far it has got.
+type progressReader struct {
+	reader   io.Reader
+	total    int64 // 0 when the size is not known in advance
+	read     int64
+	reported int64
+	interval time.Duration
+	last     time.Time
+	report   func(read, total int64)
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.reader.Read(buf)
+	p.read += int64(n)
+	if now := time.Now(); p.read > p.reported && (now.Sub(p.last) >= p.interval || err == io.EOF) {
+		p.last, p.reported = now, p.read
+		p.report(p.read, p.total)
+	}
+	return n, err
+}
+
+// with_progress wraps reader to log the progress of uploading it to
+// destination every UploadProgressInterval. It returns reader unchanged
+// when the option is not set.
+func with_progress(task BackupTask, reader io.Reader, total int64, destination string) io.Reader {
+	interval, err := time.ParseDuration(task.UploadProgressInterval)
+	if err != nil || interval <= 0 {
+		bar := new_progress_bar("Uploading "+task_name(task), total)
+		if bar == nil {
+			return reader
+		}
+		return &progressReader{
+			reader:   reader,
+			total:    total,
+			interval: bar_interval,
+			last:     time.Now(),
+			report: func(read, total int64) {
+				if total > 0 {
+					bar.set(read, total, format_bytes(uint64(read))+" / "+format_bytes(uint64(total)))
+				} else {
+					bar.set(0, 0, format_bytes(uint64(read)))
+				}
+			},
+		}
+	}
+	return &progressReader{
+		reader:   reader,
+		total:    total,
+		interval: interval,
+		last:     time.Now(),
+		report: func(read, total int64) {
+			if total > 0 {
+				log.Printf("Uploading %s to %s: %s of %s (%.0f%%)", task_name(task), destination, format_bytes(uint64(read)), format_bytes(uint64(total)), float64(read)*100/float64(total))
+			} else {
+				log.Printf("Uploading %s to %s: %s", task_name(task), destination, format_bytes(uint64(read)))
+			}
+		},
+	}
+}
+
+// throttledReader lets at most rate bytes per second through. The dump
+// writing into the pipe blocks while the reader waits, so the dump itself
+// slows down.
+type throttledReader struct {
+	reader io.Reader
+	rate   int64
+	start  time.Time
+	read   int64
+}
+
+func (t *throttledReader) Read(buf []byte) (int, error) {
+	// Reading at most a tenth of a second's worth keeps bursts small.
+	if max := t.rate / 10; max > 0 && int64(len(buf)) > max {
+		buf = buf[:max]
+	}
+	n, err := t.reader.Read(buf)
+	t.read += int64(n)
+	due := time.Duration(float64(t.read) / float64(t.rate) * float64(time.Second))
+	if wait := due - time.Since(t.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+// throttle limits reader to the task's ThrottleBytesPerSec. It returns
+// reader unchanged when the option is not set.
+func throttle(task BackupTask, reader io.Reader) io.Reader {
+	if task.ThrottleBytesPerSec <= 0 {
+		return reader
+	}
+	return &throttledReader{reader: reader, rate: task.ThrottleBytesPerSec, start: time.Now()}
+}
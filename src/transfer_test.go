@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadProgressInterval(t *testing.T) {
+	tests := []struct {
+		interval string
+		flags    string // expected in the rclone command line
+		logged   bool
+	}{
+		{"30s", "--stats 30s --stats-one-line --stats-log-level NOTICE", true},
+		{"2m", "--stats 2m0s --stats-one-line --stats-log-level NOTICE", true},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.interval, func(t *testing.T) {
+			dir := t.TempDir()
+			rclone := filepath.Join(dir, "rclone")
+			script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(dir, "args") + "\n" +
+				"echo '2026/10/14 10:00:00 NOTICE:    5.000 MiB / 10.000 MiB, 50%, 1 MiB/s, ETA 5s' >&2\n"
+			if err := os.WriteFile(rclone, []byte(script), 0755); err != nil {
+				t.Fatal(err)
+			}
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			task := BackupTask{Website: "site", StorePath: dir, RemotePath: "r:x", RclonePath: rclone, UploadProgressInterval: tt.interval}
+			if err := copy_backup_to_onedrive(task, "", 0, false); err != nil {
+				t.Fatal(err)
+			}
+			args, _ := os.ReadFile(filepath.Join(dir, "args"))
+			if tt.flags != "" && !strings.Contains(string(args), tt.flags) {
+				t.Errorf("rclone args %q lack %q", args, tt.flags)
+			}
+			if tt.flags == "" && strings.Contains(string(args), "--stats") {
+				t.Errorf("rclone args %q ask for stats", args)
+			}
+			want := "Uploading site to r:x: 5.000 MiB / 10.000 MiB, 50%"
+			if got := strings.Contains(logs.String(), want); got != tt.logged {
+				t.Errorf("log %q: has progress %v, want %v", logs.String(), got, tt.logged)
+			}
+		})
+	}
+}
+
+func TestProgressReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		interval time.Duration
+		reports  int
+	}{
+		// Every read is due with a zero interval; the final EOF read adds nothing.
+		{"every read", 4096, 0, 4},
+		// A long interval reports only at the end.
+		{"at the end", 4096, time.Hour, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reports []int64
+			reader := &progressReader{
+				reader:   bytes.NewReader(make([]byte, tt.size)),
+				total:    int64(tt.size),
+				interval: tt.interval,
+				last:     time.Now(),
+				report:   func(read, total int64) { reports = append(reports, read) },
+			}
+			buf := make([]byte, 1024)
+			for {
+				if _, err := reader.Read(buf); err == io.EOF {
+					break
+				}
+			}
+			if len(reports) != tt.reports || reports[len(reports)-1] != int64(tt.size) {
+				t.Errorf("reports %v, want %d ending at %d", reports, tt.reports, tt.size)
+			}
+		})
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	tests := []struct {
+		rate int64
+		size int
+		min  time.Duration
+	}{
+		{0, 1 << 20, 0},
+		{200 << 10, 50 << 10, 200 * time.Millisecond},
+		{1 << 20, 256 << 10, 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		source := bytes.NewReader(make([]byte, tt.size))
+		reader := throttle(BackupTask{ThrottleBytesPerSec: tt.rate}, source)
+		if tt.rate == 0 && reader != io.Reader(source) {
+			t.Errorf("rate 0 wrapped the reader")
+		}
+		start := time.Now()
+		n, err := io.Copy(io.Discard, reader)
+		if err != nil || n != int64(tt.size) {
+			t.Fatalf("rate %d: copied %d, %v", tt.rate, n, err)
+		}
+		if elapsed := time.Since(start); elapsed < tt.min {
+			t.Errorf("rate %d: %d bytes took %v, want at least %v", tt.rate, tt.size, elapsed, tt.min)
+		}
+	}
+
+	// A throttled dump holds no table locks for its longer run.
+	dir := t.TempDir()
+	mysqldump, _ := fake_mysql(t, dir)
+	for _, rate := range []int64{0, 1 << 20} {
+		os.Remove(filepath.Join(dir, "mysqldump.args"))
+		task := BackupTask{Database: "shop", StorePath: dir, MysqldumpPath: mysqldump, ThrottleBytesPerSec: rate}
+		if _, err := backup_database(task, "", 0, false); err != nil {
+			t.Fatal(err)
+		}
+		args, _ := os.ReadFile(filepath.Join(dir, "mysqldump.args"))
+		if got := strings.Contains(string(args), "--single-transaction"); got != (rate > 0) {
+			t.Errorf("rate %d: mysqldump args %q", rate, args)
+		}
+	}
+}
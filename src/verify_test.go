@@ -0,0 +1,93 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func write_test_zip(t *testing.T, path string) {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	entry, _ := archive.Create("site/index.html")
+	entry.Write(bytes.Repeat([]byte("<p>hello</p>\n"), 1000))
+	archive.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// corrupt flips bytes in the middle of path, inside the compressed data.
+func corrupt(t *testing.T, path string) {
+	t.Helper()
+	data, _ := os.ReadFile(path)
+	for i := len(data) / 3; i < len(data)/3+8; i++ {
+		data[i] ^= 0xff
+	}
+	os.WriteFile(path, data, 0644)
+}
+
+func TestVerifyBackup(t *testing.T) {
+	tests := []struct {
+		name    string
+		task    BackupTask
+		file    string
+		write   func(t *testing.T, path string)
+		corrupt bool
+	}{
+		{"good zip", BackupTask{Website: "site"}, "site-20261014-100000.zip", write_test_zip, false},
+		{"corrupt zip", BackupTask{Website: "site"}, "site-20261014-100000.zip", write_test_zip, true},
+		{"corrupt delta", BackupTask{Website: "site", DeltaMode: true}, "site-20261014-100000.delta.zip", write_test_zip, true},
+		{"good gzip dump", BackupTask{Database: "shop"}, "shop-20261014-100000.sql.gz", write_test_gzip, false},
+		{"corrupt gzip dump", BackupTask{Database: "shop", SkipUnchanged: true}, "shop-20261014-100000.sql.gz", write_test_gzip, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			task := tt.task
+			task.StorePath = dir
+			path := filepath.Join(dir, tt.file)
+			tt.write(t, path)
+			if tt.corrupt {
+				corrupt(t, path)
+			}
+			os.WriteFile(path+file_list_suffix, []byte("list\n"), 0644)
+			save_delta_state(dir, &deltaState{Baseline: "site-20261013-100000.zip"})
+			save_binlog_state(dir, map[string]string{"shop": "binlog.000001:4"})
+
+			err := verify_backup(task, []string{path}, "", 0, false)
+			if (err != nil) != tt.corrupt {
+				t.Fatalf("err = %v, want failure %v", err, tt.corrupt)
+			}
+			_, stat_err := os.Stat(path)
+			if removed := os.IsNotExist(stat_err); removed != tt.corrupt {
+				t.Errorf("backup removed %v, want %v", removed, tt.corrupt)
+			}
+			if _, err := os.Stat(path + file_list_suffix); tt.corrupt && err == nil {
+				t.Error("sidecar of the failed backup kept")
+			}
+			if state := load_delta_state(dir); (state == nil) != (tt.corrupt && task.DeltaMode) {
+				t.Errorf("delta state %v after verifying", state)
+			}
+			_, recorded := load_binlog_state(dir)["shop"]
+			if recorded == (tt.corrupt && task.SkipUnchanged) {
+				t.Errorf("binlog position recorded %v", recorded)
+			}
+		})
+	}
+}
+
+func write_test_gzip(t *testing.T, path string) {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	writer.Write(bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 1000))
+	writer.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}